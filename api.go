@@ -1,14 +1,17 @@
 package botmaid
 
 import (
+	"context"
+	"strings"
 	"time"
 
+	"github.com/catsworld/botmaid/random"
 	"github.com/spf13/pflag"
 )
 
 // API is an interface including some common behaviors for APIs.
 type API interface {
-	Pull(*PullConfig) (UpdateChannel, ErrorChannel)
+	Pull(context.Context, *PullConfig) (UpdateChannel, ErrorChannel)
 	Push(*Update) (*Update, error)
 
 	Platform() string
@@ -16,35 +19,131 @@ type API interface {
 	ats(u *User) []string
 }
 
+// fileURLResolver resolves the file of the type into a URL, or a local path
+// on the platforms which keep the files locally.
+type fileURLResolver interface {
+	fileURL(t, file string) (string, error)
+}
+
+type replyResolver interface {
+	resolveReply(update *Update)
+}
+
 // Update is a struct for an update of APIs.
 type Update struct {
 	ID   int64
 	Type string
 
-	Chat    *Chat
-	User    *User
-	Message *Message
+	Chat     *Chat
+	User     *User
+	Message  *Message
+	Event    *Event
+	Callback *Callback
+	Inline   *Inline
 
 	Time time.Time
 
 	Bot *Bot
 }
 
+// Event is a struct for an update which is not a message, the type of the
+// update is one of "member_join", "member_leave", "friend_request",
+// "group_invite", "join_request", "message_recalled", "bot_added" and
+// "bot_removed".
+//
+// The user of the update is the one joining, leaving, requesting, inviting or
+// sending the recalled message, and Operator is the one who did it if it is
+// someone else. MessageID is the ID of the recalled message. Comment is the
+// message of a request, and Flag identifies the request for Approve and
+// Reject.
+type Event struct {
+	Operator  *User
+	MessageID int64
+	Comment   string
+	Flag      string
+}
+
+// requestHandler is implemented by the APIs which could approve or reject the
+// requests.
+type requestHandler interface {
+	handleRequest(u *Update, approve bool, reason string) error
+}
+
 // UpdateChannel is a channel for saving updates.
 type UpdateChannel chan *Update
 
 // ErrorChannel is a channel for saving errors.
 type ErrorChannel chan error
 
+func (uc UpdateChannel) push(ctx context.Context, u *Update) bool {
+	select {
+	case uc <- u:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (ec ErrorChannel) push(ctx context.Context, err error) bool {
+	select {
+	case ec <- err:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// backoff returns the time waiting before the nth retry, which is doubled
+// from base for each retry but never more than max, with a random jitter in
+// its second half.
+func backoff(base, max time.Duration, n int) time.Duration {
+	d := base
+	for i := 0; i < n && (max <= 0 || d < max); i++ {
+		d *= 2
+	}
+	if max > 0 && d > max {
+		d = max
+	}
+	if d <= 1 {
+		return d
+	}
+
+	return d/2 + time.Duration(random.Int64(0, int64(d/2)))
+}
+
+// connectionUpdate returns an update for a state change of the connection to
+// the platform.
+func connectionUpdate(state string) *Update {
+	return &Update{
+		Type: state,
+		Time: time.Now(),
+	}
+}
+
 // PullConfig is a struct for pulling.
 //
 // Limit decides the number of updates pulled once.
 // Timeout decides the timeout of long polling.
 // RetryWaitingTime decides decides the time waiting after pulling an error.
+// MaxRetryWaitingTime decides the maximum time waiting for the APIs retrying
+// with exponential backoff, it is not limited if 0.
 type PullConfig struct {
-	Limit            int
-	Timeout          int
-	RetryWaitingTime time.Duration
+	Limit               int
+	Timeout             int
+	RetryWaitingTime    time.Duration
+	MaxRetryWaitingTime time.Duration
 }
 
 // Message is a struct for a message of an update.
@@ -52,7 +151,15 @@ type Message struct {
 	ID   int64
 	Type string
 
-	Content string
+	Content  string
+	Segments []*Segment
+	Buttons  [][]*Button
+	ReplyTo  *ReplyTo
+	Media    *Media
+	Location *Location
+	Contact  *Contact
+
+	Attachments []*Attachment
 
 	Args    []string
 	Command string
@@ -61,6 +168,48 @@ type Message struct {
 	Update *Update
 }
 
+// ReplyTo is a reference to the message replied by a message.
+//
+// User and Content are the sender and an excerpt of the content of the
+// message replied, which may be missing if the platform does not provide
+// them with the message, BotMaid.ResolveReply gets them if they could be got
+// later.
+type ReplyTo struct {
+	ID      int64
+	User    *User
+	Content string
+}
+
+// Location is a location of a message with the type "Location", Title and
+// Address are set if it is a venue.
+type Location struct {
+	Latitude  float64
+	Longitude float64
+
+	Title   string
+	Address string
+}
+
+// Contact is a contact of a message with the type "Contact", UserID is the ID
+// of the user on the platform if it is known.
+type Contact struct {
+	Phone  string
+	Name   string
+	UserID int64
+}
+
+// replyExcerptLength is the max number of runes of the excerpt of a message
+// replied.
+const replyExcerptLength = 50
+
+func replyExcerpt(s string) string {
+	rs := []rune(strings.TrimSpace(s))
+	if len(rs) <= replyExcerptLength {
+		return string(rs)
+	}
+	return string(rs[:replyExcerptLength]) + "…"
+}
+
 // Chat is a struct for a chat.
 type Chat struct {
 	ID   int64
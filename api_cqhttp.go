@@ -2,28 +2,58 @@ package botmaid
 
 import (
 	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/subtle"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
 )
 
 // APICqhttp is a struct stores some basic information of the CQHTTP. Please search in CQHTTP document for details.
+//
+// Mode is "websocket" (the default), "http" or "reverse", see Pull for
+// details. The APIs are always called by HTTP on APIEndpoint.
+//
+// The titles of groups and the members are cached in Cache if it is not nil.
+//
+// The buttons of messages are rendered as numbered choices, and a message
+// with only the number of a choice is handled as a callback query unless the
+// user is in a conversation. The choices of a message sent to a user could only
+// be chosen by the user, and the ones of a message without a user are chosen
+// by replying to the message.
 type APICqhttp struct {
-	AccessToken string
-	Secret      string
-	APIEndpoint string
+	AccessToken       string
+	Secret            string
+	APIEndpoint       string
 	WebsocketEndpoint string
+
+	Mode   string
+	Listen string
+	Path   string
+
+	Cache *Cache
+
+	choices buttonChoices
 }
 
 var (
+	cqCodeRegexp  = regexp.MustCompile(`\[CQ:([^,\]]+)((?:,[^,\]]*)*)\]`)
+	cqReplyRegexp = regexp.MustCompile(`\[CQ:reply(?:,[^,\]]*)*\]`)
+
 	retDescCqhttp = map[int]string{
 		0:     "Succeeded",
 		1:     "Entered asynchronous execution",
@@ -78,6 +108,185 @@ func (a *APICqhttp) API(end string, m map[string]interface{}) (interface{}, erro
 	return ret["data"], nil
 }
 
+func cqhttpEscape(s string, param bool) string {
+	s = strings.ReplaceAll(s, "&", "&amp;")
+	s = strings.ReplaceAll(s, "[", "&#91;")
+	s = strings.ReplaceAll(s, "]", "&#93;")
+	if param {
+		s = strings.ReplaceAll(s, ",", "&#44;")
+	}
+	return s
+}
+
+func cqhttpUnescape(s string) string {
+	s = strings.ReplaceAll(s, "&#44;", ",")
+	s = strings.ReplaceAll(s, "&#91;", "[")
+	s = strings.ReplaceAll(s, "&#93;", "]")
+	s = strings.ReplaceAll(s, "&amp;", "&")
+	return s
+}
+
+func cqhttpFile(file string) (string, error) {
+	if strings.HasPrefix(file, "http://") || strings.HasPrefix(file, "https://") || strings.HasPrefix(file, "base64://") {
+		return file, nil
+	}
+
+	raw, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	return "base64://" + base64.StdEncoding.EncodeToString(raw), nil
+}
+
+// cqhttpMessageFile returns the file of a message of media, the media of the
+// message is sent in base64.
+func cqhttpMessageFile(m *Message) (string, error) {
+	if m.Media == nil {
+		return cqhttpFile(m.Content)
+	}
+
+	raw, err := m.Media.bytes()
+	if err != nil {
+		return "", err
+	}
+	return "base64://" + base64.StdEncoding.EncodeToString(raw), nil
+}
+
+func (a *APICqhttp) renderSegments(ss []*Segment) (string, error) {
+	message := ""
+
+	for _, s := range ss {
+		switch s.Type {
+		case "Text":
+			message += cqhttpEscape(s.Text, false)
+		case "Mention":
+			message += fmt.Sprintf("[CQ:at,qq=%v]", s.User.ID)
+		case "Image", "Sticker", "Audio":
+			file, err := cqhttpFile(s.File)
+			if err != nil {
+				return "", fmt.Errorf("Read %v file: %v", strings.ToLower(s.Type), err)
+			}
+			if s.Type == "Audio" {
+				message += fmt.Sprintf("[CQ:record,file=%v]", cqhttpEscape(file, true))
+			} else {
+				message += fmt.Sprintf("[CQ:image,file=%v]", cqhttpEscape(file, true))
+			}
+		case "Reply":
+			message += fmt.Sprintf("[CQ:reply,id=%v]", s.ID)
+		case "Link":
+			message += fmt.Sprintf("[CQ:share,url=%v,title=%v]", cqhttpEscape(s.URL, true), cqhttpEscape(s.Text, true))
+		case "Emoji":
+			if s.ID != 0 {
+				message += fmt.Sprintf("[CQ:face,id=%v]", s.ID)
+			} else {
+				message += cqhttpEscape(s.Text, false)
+			}
+		default:
+			return "", fmt.Errorf("Unknown type of segment %v", s.Type)
+		}
+	}
+
+	return message, nil
+}
+
+// cqhttpAttachment returns the attachment of the params of an image or a
+// record.
+func cqhttpAttachment(t string, params map[string]string, update *Update) *Attachment {
+	at := &Attachment{
+		Type:   t,
+		FileID: params["file"],
+		URL:    params["url"],
+		Update: update,
+	}
+	if size, err := strconv.ParseInt(params["file_size"], 10, 64); err == nil {
+		at.Size = size
+	}
+	return at
+}
+
+func (a *APICqhttp) parseSegments(s string, update *Update) []*Segment {
+	ss := []*Segment{}
+
+	last := 0
+	for _, loc := range cqCodeRegexp.FindAllStringSubmatchIndex(s, -1) {
+		if loc[0] > last {
+			ss = append(ss, TextSegment(cqhttpUnescape(s[last:loc[0]])))
+		}
+		last = loc[1]
+
+		params := map[string]string{}
+		for _, p := range strings.Split(s[loc[4]:loc[5]], ",") {
+			kv := strings.SplitN(p, "=", 2)
+			if len(kv) == 2 {
+				params[kv[0]] = cqhttpUnescape(kv[1])
+			}
+		}
+
+		file := params["file"]
+		if params["url"] != "" {
+			file = params["url"]
+		}
+
+		switch s[loc[2]:loc[3]] {
+		case "at":
+			id, _ := strconv.ParseInt(params["qq"], 10, 64)
+			ss = append(ss, MentionSegment(&User{
+				ID:       id,
+				UserName: params["qq"],
+				Update:   update,
+			}))
+		case "image":
+			ss = append(ss, ImageSegment(file))
+			if update.Message != nil {
+				update.Message.Attachments = append(update.Message.Attachments, cqhttpAttachment("Image", params, update))
+			}
+		case "record":
+			ss = append(ss, AudioSegment(file))
+			if update.Message != nil {
+				update.Message.Attachments = append(update.Message.Attachments, cqhttpAttachment("Audio", params, update))
+			}
+		case "video":
+			if update.Message != nil {
+				update.Message.Attachments = append(update.Message.Attachments, cqhttpAttachment("Video", params, update))
+			}
+		case "location":
+			if update.Message != nil {
+				update.Message.Location = &Location{
+					Title:   params["title"],
+					Address: params["content"],
+				}
+				update.Message.Location.Latitude, _ = strconv.ParseFloat(params["lat"], 64)
+				update.Message.Location.Longitude, _ = strconv.ParseFloat(params["lon"], 64)
+			}
+		case "contact":
+			if update.Message != nil && params["type"] == "qq" {
+				id, _ := strconv.ParseInt(params["id"], 10, 64)
+				update.Message.Contact = &Contact{
+					UserID: id,
+				}
+			}
+		case "reply":
+			id, _ := strconv.ParseInt(params["id"], 10, 64)
+			ss = append(ss, &Segment{
+				Type: "Reply",
+				ID:   id,
+			})
+		case "share":
+			ss = append(ss, LinkSegment(params["url"], params["title"]))
+		case "face":
+			id, _ := strconv.ParseInt(params["id"], 10, 64)
+			ss = append(ss, EmojiSegment("", id))
+		default:
+			ss = append(ss, TextSegment(s[loc[0]:loc[1]]))
+		}
+	}
+	if last < len(s) {
+		ss = append(ss, TextSegment(cqhttpUnescape(s[last:])))
+	}
+
+	return ss
+}
+
 func (a *APICqhttp) mapToUpdates(m []interface{}) ([]*Update, error) {
 	us := []*Update{}
 	for _, v := range m {
@@ -108,6 +317,7 @@ func (a *APICqhttp) mapToUpdates(m []interface{}) ([]*Update, error) {
 			}
 
 			update.User.UserName = strconv.FormatInt(update.User.ID, 10)
+			update.Message.Segments = a.parseSegments(update.Message.Content, update)
 
 			if update.Chat.Type == "private" {
 				update.Chat.ID = int64(e["user_id"].(float64))
@@ -117,32 +327,51 @@ func (a *APICqhttp) mapToUpdates(m []interface{}) ([]*Update, error) {
 				update.Chat.ID = int64(e["discuss_id"].(float64))
 			}
 
-			if update.Chat.Type == "group" {
-				m, err := a.API("get_group_list", map[string]interface{}{})
-				if err != nil {
-					return []*Update{}, fmt.Errorf("Get updates: %v", err)
-				}
-
-				gs := m.([]interface{})
-				for _, v := range gs {
-					g := v.(map[string]interface{})
-					if int64(g["group_id"].(float64)) == update.Chat.ID {
-						update.Chat.Title = g["group_name"].(string)
-						break
+			for _, s := range update.Message.Segments {
+				if s.Type == "Reply" {
+					update.Message.ReplyTo = &ReplyTo{
+						ID: s.ID,
 					}
+					if m, ok := a.Cache.Get(CacheMessageKey(a.Platform(), update.Chat.ID, s.ID)); ok {
+						a.fillReplyTo(update.Message.ReplyTo, m, update)
+					}
+					update.Message.Content = strings.TrimSpace(cqReplyRegexp.ReplaceAllString(update.Message.Content, ""))
+					break
 				}
+			}
+			a.Cache.Set(CacheMessageKey(a.Platform(), update.Chat.ID, update.Message.ID), map[string]interface{}{
+				"sender":      e["sender"],
+				"raw_message": e["raw_message"],
+			})
+
+			if update.Chat.Type == "group" {
+				update.Chat.Title = a.groupTitle(update.Chat.ID)
 
 				u := e["sender"].(map[string]interface{})
 				update.User.NickName = u["nickname"].(string)
 				if _, ok := u["card"]; ok && u["card"].(string) != "" {
 					update.User.NickName = u["card"].(string)
 				}
+				a.Cache.Set(CacheMemberKey(a.Platform(), update.Chat.ID, update.User.ID), u)
 			} else {
 				u := e["sender"].(map[string]interface{})
 				update.User.NickName = u["nickname"].(string)
 			}
+			a.Cache.Set(CacheUserKey(a.Platform(), update.User.ID), update.User.NickName)
+
+			replyID := int64(0)
+			if update.Message.ReplyTo != nil {
+				replyID = update.Message.ReplyTo.ID
+			}
+			update.Callback = a.choices.callback(update.Chat.ID, update.User.ID, replyID, update.Message.Content)
 		} else {
-			continue
+			update = a.eventUpdate(e)
+			if e["post_type"].(string) == "notice" {
+				a.invalidate(e)
+			}
+			if update == nil {
+				continue
+			}
 		}
 
 		if update.Message != nil {
@@ -159,51 +388,319 @@ func (a *APICqhttp) mapToUpdates(m []interface{}) ([]*Update, error) {
 	return us, nil
 }
 
-// Pull pulls updates and errors into the channels with a given config.
-func (a *APICqhttp) Pull(pc *PullConfig) (UpdateChannel, ErrorChannel) {
-	updates := make(chan *Update)
-	errors := make(chan error)
+func (a *APICqhttp) handleEvent(ctx context.Context, raw []byte, updates UpdateChannel, errors ErrorChannel) bool {
+	ret := map[string]interface{}{}
+	err := json.Unmarshal(raw, &ret)
+	if err != nil {
+		return errors.push(ctx, fmt.Errorf("Get updates: %v", err))
+	}
+	if _, ok := ret["post_type"].(string); !ok {
+		// The responses of the API calls are ignored.
+		return true
+	}
 
-	var dialer *websocket.Dialer
-	conn, _, err := dialer.Dial(fmt.Sprintf(a.WebsocketEndpoint, a.AccessToken), nil)
+	us, err := a.mapToUpdates([]interface{}{ret})
 	if err != nil {
-		errors <- err
-		return updates, errors
+		return errors.push(ctx, err)
+	}
+	for _, u := range us {
+		if !updates.push(ctx, u) {
+			return false
+		}
 	}
+	return true
+}
+
+const (
+	pingPeriodCqhttp = time.Second * 30
+	pongWaitCqhttp   = pingPeriodCqhttp * 2
+)
 
+// readWebsocket reads the events from the connection until it is broken or
+// the context is done, and then closes the connection.
+//
+// A ping is sent every pingPeriodCqhttp, and the connection is regarded as
+// broken if nothing is received in pongWaitCqhttp.
+func (a *APICqhttp) readWebsocket(ctx context.Context, conn *websocket.Conn, updates UpdateChannel, errors ErrorChannel) {
+	done := make(chan struct{})
+	defer close(done)
 	go func() {
+		ticker := time.NewTicker(pingPeriodCqhttp)
+		defer ticker.Stop()
+
 		for {
-			_, message, err := conn.ReadMessage()
-			if err != nil {
-				errors <- err
-				time.Sleep(pc.RetryWaitingTime)
+			select {
+			case <-ticker.C:
+				conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(pingPeriodCqhttp))
 				continue
+			case <-ctx.Done():
+			case <-done:
+			}
+			conn.Close()
+			return
+		}
+	}()
+
+	conn.SetReadDeadline(time.Now().Add(pongWaitCqhttp))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(pongWaitCqhttp))
+	})
+
+	for {
+		_, message, err := conn.ReadMessage()
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			errors.push(ctx, fmt.Errorf("Get updates: %v", err))
+			return
+		}
+
+		conn.SetReadDeadline(time.Now().Add(pongWaitCqhttp))
+
+		if !a.handleEvent(ctx, message, updates, errors) {
+			return
+		}
+	}
+}
+
+func (a *APICqhttp) pullWebsocket(ctx context.Context, pc *PullConfig) (UpdateChannel, ErrorChannel) {
+	updates := make(UpdateChannel)
+	errors := make(ErrorChannel)
+
+	go func() {
+		defer close(updates)
+		defer close(errors)
+
+		retries := 0
+		for {
+			conn, _, err := websocket.DefaultDialer.DialContext(ctx, fmt.Sprintf(a.WebsocketEndpoint, a.AccessToken), nil)
+			if ctx.Err() != nil {
+				return
 			}
-			ret := map[string]interface{}{}
-			err = json.Unmarshal(message, &ret)
 			if err != nil {
-				errors <- err
-				time.Sleep(pc.RetryWaitingTime)
+				if !errors.push(ctx, fmt.Errorf("Connect: %v", err)) {
+					return
+				}
+				if retries == 0 && !updates.push(ctx, connectionUpdate("reconnecting")) {
+					return
+				}
+				if !sleepContext(ctx, backoff(pc.RetryWaitingTime, pc.MaxRetryWaitingTime, retries)) {
+					return
+				}
+				retries++
 				continue
 			}
 
-			m := []interface{}{}
-			m = append(m, ret)
-			us, err := a.mapToUpdates(m)
-			if err != nil {
-				errors <- err
-				time.Sleep(pc.RetryWaitingTime)
-				continue
+			retries = 0
+			if !updates.push(ctx, connectionUpdate("connected")) {
+				conn.Close()
+				return
+			}
+
+			a.readWebsocket(ctx, conn, updates, errors)
+			if ctx.Err() != nil {
+				return
+			}
+
+			if !updates.push(ctx, connectionUpdate("disconnected")) || !updates.push(ctx, connectionUpdate("reconnecting")) {
+				return
+			}
+			if !sleepContext(ctx, backoff(pc.RetryWaitingTime, pc.MaxRetryWaitingTime, 0)) {
+				return
+			}
+			retries++
+		}
+	}()
+
+	return updates, errors
+}
+
+func (a *APICqhttp) authorized(r *http.Request) bool {
+	if a.AccessToken == "" {
+		return true
+	}
+
+	token := r.URL.Query().Get("access_token")
+	if h := r.Header.Get("Authorization"); h != "" {
+		token = strings.TrimPrefix(strings.TrimPrefix(h, "Bearer "), "Token ")
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(a.AccessToken)) == 1
+}
+
+func (a *APICqhttp) verifySignature(r *http.Request, raw []byte) bool {
+	if a.Secret == "" {
+		return true
+	}
+
+	mac := hmac.New(sha1.New, []byte(a.Secret))
+	mac.Write(raw)
+	sig := "sha1=" + hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(r.Header.Get("X-Signature")), []byte(sig))
+}
+
+func (a *APICqhttp) httpHandler(ctx context.Context, updates UpdateChannel, errors ErrorChannel) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		raw, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+
+		if !a.verifySignature(r, raw) {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		if !a.handleEvent(ctx, raw, updates, errors) {
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (a *APICqhttp) reverseWebsocketHandler(ctx context.Context, wg *sync.WaitGroup, updates UpdateChannel, errors ErrorChannel) http.HandlerFunc {
+	upgrader := &websocket.Upgrader{}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !a.authorized(r) {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		wg.Add(1)
+		defer wg.Done()
+		if ctx.Err() != nil {
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			errors.push(ctx, fmt.Errorf("Reverse websocket: %v", err))
+			return
+		}
+
+		a.readWebsocket(ctx, conn, updates, errors)
+	}
+}
+
+// pullServer receives the events by a server listening on Listen, which
+// accepts the HTTP POST requests or the reverse websocket connections from
+// CQHTTP.
+func (a *APICqhttp) pullServer(ctx context.Context, pc *PullConfig) (UpdateChannel, ErrorChannel) {
+	updates := make(UpdateChannel)
+	errors := make(ErrorChannel)
+
+	path := a.Path
+	if path == "" {
+		path = "/"
+	}
+
+	// wg tracks the handlers of the reverse websocket connections, which are
+	// not waited by the shutdown of the server.
+	wg := &sync.WaitGroup{}
+
+	mux := http.NewServeMux()
+	if a.Mode == "reverse" {
+		mux.Handle(path, a.reverseWebsocketHandler(ctx, wg, updates, errors))
+	} else {
+		mux.Handle(path, a.httpHandler(ctx, updates, errors))
+	}
+
+	server := &http.Server{
+		Addr:    a.Listen,
+		Handler: mux,
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		<-ctx.Done()
+		server.Shutdown(context.Background())
+		close(closed)
+	}()
+
+	go func() {
+		defer close(updates)
+		defer close(errors)
+
+		for {
+			err := server.ListenAndServe()
+			if err == http.ErrServerClosed || ctx.Err() != nil {
+				break
 			}
-			for _, u := range us {
-				updates <- u
+			if !errors.push(ctx, fmt.Errorf("Listen: %v", err)) || !sleepContext(ctx, pc.RetryWaitingTime) {
+				break
 			}
 		}
+
+		<-closed
+		wg.Wait()
 	}()
 
 	return updates, errors
 }
 
+// Pull pulls updates and errors into the channels with a given config.
+//
+// The events are received from the websocket on WebsocketEndpoint by
+// default, which is redialed with exponential backoff if it is broken, and
+// the updates of the types "connected", "disconnected" and "reconnecting"
+// without Message are pulled when the state of the connection changes.
+//
+// The events are received by a server listening on Listen instead if Mode is
+// "http" (HTTP POST) or "reverse" (reverse websocket).
+func (a *APICqhttp) Pull(ctx context.Context, pc *PullConfig) (UpdateChannel, ErrorChannel) {
+	if a.Mode == "http" || a.Mode == "reverse" {
+		return a.pullServer(ctx, pc)
+	}
+
+	return a.pullWebsocket(ctx, pc)
+}
+
+// uploadFile uploads the file at the path in the content of the message into
+// the chat, the file must be on the machine running CQHTTP.
+func (a *APICqhttp) uploadFile(update *Update) (*Update, error) {
+	if update.Message.Media != nil || isURL(update.Message.Content) {
+		return nil, errors.New("Send document: Only local files are supported")
+	}
+
+	file, err := filepath.Abs(update.Message.Content)
+	if err != nil {
+		return nil, fmt.Errorf("Send document: %v", err)
+	}
+
+	m := map[string]interface{}{
+		"file": file,
+		"name": filepath.Base(file),
+	}
+	end := ""
+	switch update.Chat.Type {
+	case "group":
+		end = "upload_group_file"
+		m["group_id"] = update.Chat.ID
+	case "private":
+		end = "upload_private_file"
+		m["user_id"] = update.Chat.ID
+	default:
+		return nil, fmt.Errorf("Send document: Not supported in %v chats", update.Chat.Type)
+	}
+
+	_, err = a.API(end, m)
+	if err != nil {
+		return nil, fmt.Errorf("Send document: %v", err)
+	}
+
+	// No message ID is returned for the files uploaded.
+	return update, nil
+}
+
 // Push pushes an update and returns it back if existing.
 func (a *APICqhttp) Push(update *Update) (*Update, error) {
 	if update.Type == "Delete" {
@@ -216,6 +713,33 @@ func (a *APICqhttp) Push(update *Update) (*Update, error) {
 
 		return nil, nil
 	}
+	if update.Type == "Edit" {
+		// Messages could not be edited on QQ, so it is sent again and the old
+		// one is deleted only after that succeeds. The new message is still
+		// returned if the old one could not be deleted.
+		sent, err := a.Push(&Update{
+			Message: &Message{
+				Content: update.Message.Content,
+				ReplyTo: update.Message.ReplyTo,
+				Buttons: update.Message.Buttons,
+			},
+			Chat: update.Chat,
+			User: update.User,
+			Bot:  update.Bot,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Edit message: %v", err)
+		}
+
+		_, err = a.API("delete_msg", map[string]interface{}{
+			"message_id": update.ID,
+		})
+		if err != nil {
+			return sent, fmt.Errorf("Edit message: %v", err)
+		}
+
+		return sent, nil
+	}
 
 	m := map[string]interface{}{
 		"message_type": update.Chat.Type,
@@ -232,31 +756,54 @@ func (a *APICqhttp) Push(update *Update) (*Update, error) {
 	}
 
 	message := ""
+	if update.Message.ReplyTo != nil {
+		message += fmt.Sprintf("[CQ:reply,id=%v]", update.Message.ReplyTo.ID)
+	}
 
-	if update.Message.Type == "Audio" {
-		if strings.HasPrefix(update.Message.Content, "http://") || strings.HasPrefix(update.Message.Content, "https://") {
-			message += fmt.Sprintf("[CQ:record,file=%v]", update.Message.Content)
-		} else {
-			file, err := ioutil.ReadFile(update.Message.Content)
-			if err != nil {
-				return nil, fmt.Errorf("Read audio file: %v", err)
-			}
-			message += fmt.Sprintf("[CQ:record,file=base64://%v]", base64.StdEncoding.EncodeToString(file))
+	if len(update.Message.Segments) != 0 {
+		s, err := a.renderSegments(update.Message.Segments)
+		if err != nil {
+			return nil, fmt.Errorf("Send message: %v", err)
 		}
-	} else if update.Message.Type == "Image" || update.Message.Type == "Sticker" {
-		if strings.HasPrefix(update.Message.Content, "http://") || strings.HasPrefix(update.Message.Content, "https://") {
-			message += fmt.Sprintf("[CQ:image,file=%v]", update.Message.Content)
-		} else {
-			file, err := ioutil.ReadFile(update.Message.Content)
-			if err != nil {
-				return nil, fmt.Errorf("Read image file: %v", err)
-			}
-			message += fmt.Sprintf("[CQ:image,file=base64://%v]", base64.StdEncoding.EncodeToString(file))
+		message += s
+	} else if Contains([]string{"Image", "Sticker", "Audio", "Video"}, update.Message.Type) {
+		file, err := cqhttpMessageFile(update.Message)
+		if err != nil {
+			return nil, fmt.Errorf("Read %v file: %v", strings.ToLower(update.Message.Type), err)
 		}
+
+		switch update.Message.Type {
+		case "Audio":
+			message += fmt.Sprintf("[CQ:record,file=%v]", cqhttpEscape(file, true))
+		case "Video":
+			message += fmt.Sprintf("[CQ:video,file=%v]", cqhttpEscape(file, true))
+		default:
+			message += fmt.Sprintf("[CQ:image,file=%v]", cqhttpEscape(file, true))
+		}
+	} else if update.Message.Type == "Document" {
+		return a.uploadFile(update)
+	} else if update.Message.Type == "Location" {
+		l := update.Message.Location
+		if l == nil {
+			return nil, errors.New("Send location: Missing location")
+		}
+		message += fmt.Sprintf("[CQ:location,lat=%v,lon=%v,title=%v,content=%v]", l.Latitude, l.Longitude, cqhttpEscape(l.Title, true), cqhttpEscape(l.Address, true))
+	} else if update.Message.Type == "Contact" {
+		c := update.Message.Contact
+		if c == nil {
+			return nil, errors.New("Send contact: Missing contact")
+		}
+		if c.UserID == 0 {
+			return nil, errors.New("Send contact: Not supported without the QQ number")
+		}
+		message += fmt.Sprintf("[CQ:contact,type=qq,id=%v]", c.UserID)
 	} else {
 		message += strings.TrimSpace(update.Message.Content)
 	}
 
+	s, data := a.choices.render("", update.Message.Buttons)
+	message += cqhttpEscape(s, false)
+
 	m["message"] = message
 
 	msg, err := a.API("send_msg", m)
@@ -265,10 +812,281 @@ func (a *APICqhttp) Push(update *Update) (*Update, error) {
 	}
 
 	update.ID = int64(msg.(map[string]interface{})["message_id"].(float64))
+	userID := int64(0)
+	if update.User != nil {
+		userID = update.User.ID
+	}
+	a.choices.remember(update.Chat.ID, userID, update.ID, data)
+	if update.Bot != nil && update.Bot.Self != nil {
+		a.Cache.Set(CacheMessageKey(a.Platform(), update.Chat.ID, update.ID), map[string]interface{}{
+			"sender": map[string]interface{}{
+				"user_id":  float64(update.Bot.Self.ID),
+				"nickname": update.Bot.Self.NickName,
+			},
+			"raw_message": message,
+		})
+	}
 
 	return update, nil
 }
 
+func (a *APICqhttp) groupTitle(id int64) string {
+	m, err := a.Cache.Fetch(CacheChatKey(a.Platform(), id), func() (interface{}, error) {
+		return a.API("get_group_info", map[string]interface{}{
+			"group_id": id,
+		})
+	})
+	if err != nil {
+		return ""
+	}
+
+	title, _ := m.(map[string]interface{})["group_name"].(string)
+	return title
+}
+
+func (a *APICqhttp) takeChoice(u *Update) bool {
+	return a.choices.take(u.Chat.ID, u.Callback.MessageID)
+}
+
+// fileURL returns the URL of an image, or the path of a record on the host of
+// CQHTTP, the files of other types could not be got.
+func (a *APICqhttp) fileURL(t, file string) (string, error) {
+	switch t {
+	case "Image", "Sticker":
+		m, err := a.API("get_image", map[string]interface{}{
+			"file": file,
+		})
+		if err != nil {
+			return "", fmt.Errorf("Get image: %v", err)
+		}
+
+		url, ok := m.(map[string]interface{})["url"].(string)
+		if !ok || url == "" {
+			return "", errors.New("Get image: File is not available")
+		}
+		return url, nil
+	case "Audio":
+		m, err := a.API("get_record", map[string]interface{}{
+			"file":       file,
+			"out_format": "mp3",
+		})
+		if err != nil {
+			return "", fmt.Errorf("Get record: %v", err)
+		}
+
+		path, ok := m.(map[string]interface{})["file"].(string)
+		if !ok || path == "" {
+			return "", errors.New("Get record: File is not available")
+		}
+		return path, nil
+	}
+
+	return "", fmt.Errorf("Get file: Not supported for the type %v", t)
+}
+
+// resolveReply fills the sender and the content of the message replied by
+// the message of the update, the message is got from the platform if it is not
+// cached, and they are kept missing if it could not be got.
+func (a *APICqhttp) resolveReply(update *Update) {
+	r := update.Message.ReplyTo
+	m, err := a.Cache.Fetch(CacheMessageKey(a.Platform(), update.Chat.ID, r.ID), func() (interface{}, error) {
+		return a.API("get_msg", map[string]interface{}{
+			"message_id": r.ID,
+		})
+	})
+	if err != nil {
+		return
+	}
+
+	a.fillReplyTo(r, m, update)
+}
+
+// fillReplyTo fills the sender and the content of the reference with the
+// message got by get_msg.
+func (a *APICqhttp) fillReplyTo(r *ReplyTo, msg interface{}, update *Update) {
+	m, ok := msg.(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	if f, ok := m["sender"].(map[string]interface{}); ok {
+		uid, _ := f["user_id"].(float64)
+		r.User = &User{
+			ID:       int64(uid),
+			UserName: strconv.FormatInt(int64(uid), 10),
+			Update:   update,
+		}
+		r.User.NickName, _ = f["nickname"].(string)
+	}
+
+	s, ok := m["raw_message"].(string)
+	if !ok {
+		s, _ = m["message"].(string)
+	}
+	text := ""
+	for _, seg := range a.parseSegments(s, &Update{}) {
+		if seg.Type == "Text" {
+			text += seg.Text
+		}
+	}
+	r.Content = replyExcerpt(text)
+}
+
+// eventUpdate returns the update of a notice or a request, or nil if it is
+// not supported.
+func (a *APICqhttp) eventUpdate(e map[string]interface{}) *Update {
+	id := func(k string) int64 {
+		f, _ := e[k].(float64)
+		return int64(f)
+	}
+
+	update := &Update{
+		Time: time.Unix(id("time"), 0),
+		User: &User{
+			ID:       id("user_id"),
+			UserName: strconv.FormatInt(id("user_id"), 10),
+		},
+		Event: &Event{},
+	}
+	if e["group_id"] != nil {
+		update.Chat = &Chat{
+			ID:    id("group_id"),
+			Type:  "group",
+			Title: a.groupTitle(id("group_id")),
+		}
+	}
+	if id("operator_id") != 0 && id("operator_id") != id("user_id") {
+		update.Event.Operator = &User{
+			ID:       id("operator_id"),
+			UserName: strconv.FormatInt(id("operator_id"), 10),
+			Update:   update,
+		}
+	}
+
+	switch e["post_type"] {
+	case "notice":
+		switch e["notice_type"] {
+		case "group_increase":
+			update.Type = "member_join"
+			if id("user_id") == id("self_id") {
+				update.Type = "bot_added"
+			}
+		case "group_decrease":
+			update.Type = "member_leave"
+			if e["sub_type"] == "kick_me" || id("user_id") == id("self_id") {
+				update.Type = "bot_removed"
+			}
+		case "group_recall", "friend_recall":
+			update.Type = "message_recalled"
+			update.Event.MessageID = id("message_id")
+			if update.Chat == nil {
+				update.Chat = &Chat{
+					ID:   id("user_id"),
+					Type: "private",
+				}
+			}
+		default:
+			return nil
+		}
+	case "request":
+		update.Event.Comment, _ = e["comment"].(string)
+		update.Event.Flag, _ = e["flag"].(string)
+
+		switch e["request_type"] {
+		case "friend":
+			update.Type = "friend_request"
+		case "group":
+			update.Type = "join_request"
+			if e["sub_type"] == "invite" {
+				update.Type = "group_invite"
+			}
+		default:
+			return nil
+		}
+	default:
+		return nil
+	}
+
+	if u, ok := a.Cache.Get(CacheUserKey(a.Platform(), update.User.ID)); ok {
+		update.User.NickName, _ = u.(string)
+	}
+
+	return update
+}
+
+func (a *APICqhttp) handleRequest(u *Update, approve bool, reason string) error {
+	if u.Event == nil || u.Event.Flag == "" {
+		return errors.New("Handle request: Not a request")
+	}
+
+	var err error
+	switch u.Type {
+	case "friend_request":
+		_, err = a.API("set_friend_add_request", map[string]interface{}{
+			"flag":    u.Event.Flag,
+			"approve": approve,
+		})
+	case "group_invite", "join_request":
+		subType := "add"
+		if u.Type == "group_invite" {
+			subType = "invite"
+		}
+		_, err = a.API("set_group_add_request", map[string]interface{}{
+			"flag":     u.Event.Flag,
+			"sub_type": subType,
+			"approve":  approve,
+			"reason":   reason,
+		})
+	default:
+		return errors.New("Handle request: Not a request")
+	}
+	if err != nil {
+		return fmt.Errorf("Handle request: %v", err)
+	}
+	return nil
+}
+
+// invalidate invalidates the cache for the notices changing groups or members.
+func (a *APICqhttp) invalidate(e map[string]interface{}) {
+	gid, _ := e["group_id"].(float64)
+	uid, _ := e["user_id"].(float64)
+	self, _ := e["self_id"].(float64)
+
+	switch e["notice_type"] {
+	case "group_increase", "group_decrease":
+		if uid == self {
+			a.Cache.InvalidateChat(a.Platform(), int64(gid))
+			return
+		}
+		a.Cache.Invalidate(CacheMemberKey(a.Platform(), int64(gid), int64(uid)))
+	case "group_admin", "group_card":
+		a.Cache.Invalidate(CacheMemberKey(a.Platform(), int64(gid), int64(uid)))
+	case "group_name":
+		a.Cache.InvalidateChat(a.Platform(), int64(gid))
+	case "friend_add":
+		a.Cache.Invalidate(CacheUserKey(a.Platform(), int64(uid)))
+	}
+}
+
+func (a *APICqhttp) isChatAdmin(c *Chat, u *User) (bool, error) {
+	if c.Type != "group" {
+		return false, nil
+	}
+
+	m, err := a.Cache.Fetch(CacheMemberKey(a.Platform(), c.ID, u.ID), func() (interface{}, error) {
+		return a.API("get_group_member_info", map[string]interface{}{
+			"group_id": c.ID,
+			"user_id":  u.ID,
+		})
+	})
+	if err != nil {
+		return false, fmt.Errorf("Get member: %v", err)
+	}
+
+	role, _ := m.(map[string]interface{})["role"].(string)
+	return role == "owner" || role == "admin", nil
+}
+
 // Platform returns a string showing the platform of the bot.
 func (a *APICqhttp) Platform() string {
 	return "QQ"
@@ -0,0 +1,724 @@
+package botmaid
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// APIDiscord is a struct stores some basic information of the Discord API. Please search in official API document for details.
+//
+// GatewayEndpoint and APIEndpoint could be set to use a custom server, the
+// official ones will be used if they are empty.
+type APIDiscord struct {
+	Token           string
+	GatewayEndpoint string
+	APIEndpoint     string
+
+	mu        sync.Mutex
+	sessionID string
+	resumeURL string
+	seq       int64
+	selfID    int64
+}
+
+const (
+	endPointAPIDiscord     = "https://discord.com/api/v10"
+	endPointGatewayDiscord = "wss://gateway.discord.gg/?v=10&encoding=json"
+
+	// GUILDS | GUILD_MESSAGES | DIRECT_MESSAGES | MESSAGE_CONTENT
+	intentsDiscord = 1<<0 | 1<<9 | 1<<12 | 1<<15
+)
+
+var mentionRegexpDiscord = regexp.MustCompile(`<@!?(\d+)>`)
+
+func (a *APIDiscord) apiEndpoint() string {
+	if a.APIEndpoint != "" {
+		return strings.TrimSuffix(a.APIEndpoint, "/")
+	}
+	return endPointAPIDiscord
+}
+
+func (a *APIDiscord) gatewayEndpoint() string {
+	if a.GatewayEndpoint != "" {
+		return a.GatewayEndpoint
+	}
+	return endPointGatewayDiscord
+}
+
+func (a *APIDiscord) do(req *http.Request, end string) (interface{}, error) {
+	req.Header.Set("Authorization", "Bot "+a.Token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("API %v: %v", end, err)
+	}
+	defer resp.Body.Close()
+
+	raw, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("API %v: %v", end, err)
+	}
+
+	if resp.StatusCode == http.StatusNoContent {
+		return nil, nil
+	}
+
+	var ret interface{}
+	err = json.Unmarshal(raw, &ret)
+	if err != nil {
+		return nil, fmt.Errorf("API %v: %v", end, err)
+	}
+
+	if resp.StatusCode >= 400 {
+		if m, ok := ret.(map[string]interface{}); ok && m["message"] != nil {
+			return nil, fmt.Errorf("API %v: %v", end, m["message"])
+		}
+		return nil, fmt.Errorf("API %v: %v", end, resp.Status)
+	}
+
+	return ret, nil
+}
+
+// API returns the body of an HTTP response to the Discord API.
+func (a *APIDiscord) API(method, end string, m map[string]interface{}) (interface{}, error) {
+	var body io.Reader
+	if m != nil {
+		j, err := json.Marshal(m)
+		if err != nil {
+			return nil, fmt.Errorf("API %v: %v", end, err)
+		}
+		body = bytes.NewBuffer(j)
+	}
+
+	req, err := http.NewRequest(method, a.apiEndpoint()+end, body)
+	if err != nil {
+		return nil, fmt.Errorf("API %v: %v", end, err)
+	}
+	if m != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	return a.do(req, end)
+}
+
+func (a *APIDiscord) upload(end string, md *Media, m map[string]interface{}) (interface{}, error) {
+	buf := new(bytes.Buffer)
+	w := multipart.NewWriter(buf)
+
+	j, err := json.Marshal(m)
+	if err != nil {
+		return nil, fmt.Errorf("API %v: %v", end, err)
+	}
+	w.WriteField("payload_json", string(j))
+
+	part, err := w.CreateFormFile("files[0]", md.Name)
+	if err != nil {
+		return nil, fmt.Errorf("API %v: %v", end, err)
+	}
+	_, err = io.Copy(part, md.reader())
+	if err != nil {
+		return nil, fmt.Errorf("API %v: %v", end, err)
+	}
+	w.Close()
+
+	req, err := http.NewRequest("POST", a.apiEndpoint()+end, buf)
+	if err != nil {
+		return nil, fmt.Errorf("API %v: %v", end, err)
+	}
+	req.Header.Set("Content-Type", w.FormDataContentType())
+
+	return a.do(req, end)
+}
+
+func parseSnowflake(v interface{}) int64 {
+	s, _ := v.(string)
+	id, _ := strconv.ParseInt(s, 10, 64)
+	return id
+}
+
+func (a *APIDiscord) parseSegments(s string, update *Update) []*Segment {
+	ss := []*Segment{}
+
+	last := 0
+	for _, loc := range mentionRegexpDiscord.FindAllStringSubmatchIndex(s, -1) {
+		if loc[0] > last {
+			ss = append(ss, TextSegment(s[last:loc[0]]))
+		}
+		last = loc[1]
+
+		id, _ := strconv.ParseInt(s[loc[2]:loc[3]], 10, 64)
+		ss = append(ss, MentionSegment(&User{
+			ID:     id,
+			Update: update,
+		}))
+	}
+	if last < len(s) {
+		ss = append(ss, TextSegment(s[last:]))
+	}
+
+	return ss
+}
+
+func (a *APIDiscord) mapToUpdates(m []interface{}) ([]*Update, error) {
+	us := []*Update{}
+	for _, v := range m {
+		e := v.(map[string]interface{})
+
+		// The partial updates of messages, like the ones with the embeds
+		// resolved, come without the author or the content.
+		f, ok := e["author"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		content, ok := e["content"].(string)
+		if !ok {
+			continue
+		}
+
+		a.mu.Lock()
+		selfID := a.selfID
+		a.mu.Unlock()
+		if parseSnowflake(f["id"]) == selfID {
+			continue
+		}
+
+		update := &Update{
+			ID: parseSnowflake(e["id"]),
+
+			Type: "message_text",
+
+			Chat: &Chat{
+				ID:   parseSnowflake(e["channel_id"]),
+				Type: "private",
+			},
+
+			User: &User{
+				ID: parseSnowflake(f["id"]),
+			},
+
+			Message: &Message{
+				ID: parseSnowflake(e["id"]),
+			},
+		}
+
+		if s, ok := e["timestamp"].(string); ok {
+			t, err := time.Parse(time.RFC3339, s)
+			if err != nil {
+				return []*Update{}, fmt.Errorf("Get updates: %v", err)
+			}
+			update.Time = t
+		}
+		if s, ok := e["edited_timestamp"].(string); ok {
+			t, err := time.Parse(time.RFC3339, s)
+			if err != nil {
+				return []*Update{}, fmt.Errorf("Get updates: %v", err)
+			}
+			update.Type = "message_edited"
+			update.Time = t
+		}
+
+		if _, ok := e["guild_id"]; ok {
+			update.Chat.Type = "guild"
+		}
+
+		update.Message.Content = content
+
+		if r, ok := e["referenced_message"].(map[string]interface{}); ok {
+			update.Message.Segments = append(update.Message.Segments, &Segment{
+				Type: "Reply",
+				ID:   parseSnowflake(r["id"]),
+			})
+
+			update.Message.ReplyTo = &ReplyTo{
+				ID: parseSnowflake(r["id"]),
+			}
+			if f, ok := r["author"].(map[string]interface{}); ok {
+				update.Message.ReplyTo.User = &User{
+					ID:     parseSnowflake(f["id"]),
+					Update: update,
+				}
+				if s, ok := f["username"].(string); ok {
+					update.Message.ReplyTo.User.UserName = s
+					update.Message.ReplyTo.User.NickName = s
+				}
+			}
+			if s, ok := r["content"].(string); ok {
+				update.Message.ReplyTo.Content = replyExcerpt(s)
+			}
+		}
+		update.Message.Segments = append(update.Message.Segments, a.parseSegments(update.Message.Content, update)...)
+		if as, ok := e["attachments"].([]interface{}); ok {
+			for _, v := range as {
+				at := v.(map[string]interface{})
+				url, _ := at["url"].(string)
+				ct, _ := at["content_type"].(string)
+				if strings.HasPrefix(ct, "audio/") {
+					update.Message.Segments = append(update.Message.Segments, AudioSegment(url))
+				} else if strings.HasPrefix(ct, "image/") {
+					update.Message.Segments = append(update.Message.Segments, ImageSegment(url))
+				}
+
+				attachment := &Attachment{
+					Type:   attachmentType(ct),
+					FileID: fmt.Sprint(at["id"]),
+					URL:    url,
+					MIME:   ct,
+					Update: update,
+				}
+				attachment.Name, _ = at["filename"].(string)
+				if f, ok := at["size"].(float64); ok {
+					attachment.Size = int64(f)
+				}
+				update.Message.Attachments = append(update.Message.Attachments, attachment)
+			}
+		}
+
+		if s, ok := f["username"].(string); ok {
+			update.User.UserName = s
+			update.User.NickName = s
+		}
+		if s, ok := f["global_name"].(string); ok && s != "" {
+			update.User.NickName = s
+		}
+		if mb, ok := e["member"].(map[string]interface{}); ok {
+			if s, ok := mb["nick"].(string); ok && s != "" {
+				update.User.NickName = s
+			}
+		}
+
+		update.Message.Update = update
+		update.Chat.Update = update
+		update.User.Update = update
+		us = append(us, update)
+	}
+	return us, nil
+}
+
+type discordPayload struct {
+	Op int             `json:"op"`
+	D  json.RawMessage `json:"d"`
+	S  *int64          `json:"s,omitempty"`
+	T  string          `json:"t,omitempty"`
+}
+
+func (a *APIDiscord) serve(ctx context.Context, conn *websocket.Conn, updates UpdateChannel) error {
+	var writeMu sync.Mutex
+	write := func(op int, d interface{}) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+
+		return conn.WriteJSON(map[string]interface{}{
+			"op": op,
+			"d":  d,
+		})
+	}
+	heartbeat := func() error {
+		a.mu.Lock()
+		seq := a.seq
+		a.mu.Unlock()
+
+		if seq == 0 {
+			return write(1, nil)
+		}
+		return write(1, seq)
+	}
+
+	p := discordPayload{}
+	err := conn.ReadJSON(&p)
+	if err != nil {
+		return err
+	}
+	if p.Op != 10 {
+		return fmt.Errorf("Gateway: Expected hello but got op %v", p.Op)
+	}
+	hello := struct {
+		HeartbeatInterval int64 `json:"heartbeat_interval"`
+	}{}
+	err = json.Unmarshal(p.D, &hello)
+	if err != nil {
+		return fmt.Errorf("Gateway: %v", err)
+	}
+
+	a.mu.Lock()
+	sessionID, seq := a.sessionID, a.seq
+	a.mu.Unlock()
+
+	if sessionID != "" {
+		err = write(6, map[string]interface{}{
+			"token":      a.Token,
+			"session_id": sessionID,
+			"seq":        seq,
+		})
+	} else {
+		err = write(2, map[string]interface{}{
+			"token":   a.Token,
+			"intents": intentsDiscord,
+			"properties": map[string]interface{}{
+				"os":      "linux",
+				"browser": "botmaid",
+				"device":  "botmaid",
+			},
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	var ackMu sync.Mutex
+	acked := true
+
+	go func() {
+		ticker := time.NewTicker(time.Duration(hello.HeartbeatInterval) * time.Millisecond)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				ackMu.Lock()
+				if !acked {
+					ackMu.Unlock()
+					conn.Close()
+					return
+				}
+				acked = false
+				ackMu.Unlock()
+
+				if heartbeat() != nil {
+					conn.Close()
+					return
+				}
+			case <-ctx.Done():
+				conn.Close()
+				return
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	for {
+		p := discordPayload{}
+		err := conn.ReadJSON(&p)
+		if err != nil {
+			return err
+		}
+
+		if p.S != nil {
+			a.mu.Lock()
+			a.seq = *p.S
+			a.mu.Unlock()
+		}
+
+		switch p.Op {
+		case 0:
+			if p.T == "READY" {
+				ready := struct {
+					SessionID        string `json:"session_id"`
+					ResumeGatewayURL string `json:"resume_gateway_url"`
+					User             struct {
+						ID string `json:"id"`
+					} `json:"user"`
+				}{}
+				err := json.Unmarshal(p.D, &ready)
+				if err != nil {
+					return fmt.Errorf("Gateway: %v", err)
+				}
+
+				a.mu.Lock()
+				a.sessionID = ready.SessionID
+				a.resumeURL = ready.ResumeGatewayURL
+				a.selfID, _ = strconv.ParseInt(ready.User.ID, 10, 64)
+				a.mu.Unlock()
+			}
+
+			if p.T == "MESSAGE_CREATE" || p.T == "MESSAGE_UPDATE" {
+				d := map[string]interface{}{}
+				err := json.Unmarshal(p.D, &d)
+				if err != nil {
+					return fmt.Errorf("Gateway: %v", err)
+				}
+				if _, ok := d["edited_timestamp"].(string); p.T == "MESSAGE_UPDATE" && !ok {
+					// The message is updated by Discord rather than edited.
+					continue
+				}
+
+				us, err := a.mapToUpdates([]interface{}{d})
+				if err != nil {
+					return err
+				}
+				for _, u := range us {
+					if !updates.push(ctx, u) {
+						return ctx.Err()
+					}
+				}
+			}
+		case 1:
+			err := heartbeat()
+			if err != nil {
+				return err
+			}
+		case 7:
+			return errors.New("Gateway: Reconnect requested")
+		case 9:
+			resumable := false
+			json.Unmarshal(p.D, &resumable)
+			if !resumable {
+				a.mu.Lock()
+				a.sessionID = ""
+				a.resumeURL = ""
+				a.seq = 0
+				a.mu.Unlock()
+			}
+			return errors.New("Gateway: Invalid session")
+		case 11:
+			ackMu.Lock()
+			acked = true
+			ackMu.Unlock()
+		}
+	}
+}
+
+// Pull pulls updates and errors into the channels with a given config.
+//
+// The session will be resumed after the connection to the gateway is broken.
+func (a *APIDiscord) Pull(ctx context.Context, pc *PullConfig) (UpdateChannel, ErrorChannel) {
+	updates := make(UpdateChannel)
+	errors := make(ErrorChannel)
+
+	go func() {
+		defer close(updates)
+		defer close(errors)
+
+		for {
+			endpoint := a.gatewayEndpoint()
+			a.mu.Lock()
+			if a.sessionID != "" && a.resumeURL != "" && a.GatewayEndpoint == "" {
+				endpoint = a.resumeURL + "/?v=10&encoding=json"
+			}
+			a.mu.Unlock()
+
+			var dialer *websocket.Dialer
+			conn, _, err := dialer.DialContext(ctx, endpoint, nil)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				if !errors.push(ctx, err) || !sleepContext(ctx, pc.RetryWaitingTime) {
+					return
+				}
+				continue
+			}
+
+			err = a.serve(ctx, conn, updates)
+			conn.Close()
+			if ctx.Err() != nil {
+				return
+			}
+			if !errors.push(ctx, err) || !sleepContext(ctx, pc.RetryWaitingTime) {
+				return
+			}
+		}
+	}()
+
+	return updates, errors
+}
+
+func (a *APIDiscord) pushSegments(update *Update) (*Update, error) {
+	text := ""
+	replyTo := int64(0)
+	if update.Message.ReplyTo != nil {
+		replyTo = update.Message.ReplyTo.ID
+	}
+	media := []*Segment{}
+
+	for _, s := range update.Message.Segments {
+		switch s.Type {
+		case "Text", "Emoji":
+			text += s.Text
+		case "Mention":
+			text += fmt.Sprintf("<@%v>", s.User.ID)
+		case "Link":
+			if s.Text == "" {
+				text += s.URL
+			} else {
+				text += fmt.Sprintf("[%v](%v)", s.Text, s.URL)
+			}
+		case "Reply":
+			replyTo = s.ID
+		case "Image", "Audio", "Sticker":
+			media = append(media, s)
+		default:
+			return nil, fmt.Errorf("Send message: Unknown type of segment %v", s.Type)
+		}
+	}
+
+	var ret *Update
+
+	if strings.TrimSpace(text) != "" {
+		m := map[string]interface{}{
+			"content": strings.TrimSpace(text),
+		}
+		if replyTo != 0 {
+			m["message_reference"] = map[string]interface{}{
+				"message_id": strconv.FormatInt(replyTo, 10),
+			}
+		}
+
+		msg, err := a.API("POST", fmt.Sprintf("/channels/%v/messages", update.Chat.ID), m)
+		if err != nil {
+			return nil, fmt.Errorf("Send text message: %v", err)
+		}
+
+		update.ID = parseSnowflake(msg.(map[string]interface{})["id"])
+		ret = update
+	}
+
+	for _, s := range media {
+		u, err := a.Push(&Update{
+			Chat: update.Chat,
+			Message: &Message{
+				Type:    s.Type,
+				Content: s.File,
+			},
+		})
+		if err != nil {
+			// The update is returned with the error if the text or some of the
+			// media have been sent.
+			return ret, err
+		}
+
+		if ret == nil {
+			update.ID = u.ID
+			ret = update
+		}
+	}
+
+	return ret, nil
+}
+
+// Push pushes an update and returns it back if existing.
+func (a *APIDiscord) Push(update *Update) (*Update, error) {
+	end := fmt.Sprintf("/channels/%v/messages", update.Chat.ID)
+
+	if update.Type == "Delete" {
+		_, err := a.API("DELETE", fmt.Sprintf("%v/%v", end, update.ID), nil)
+		if err != nil {
+			return nil, fmt.Errorf("Delete message: %v", err)
+		}
+
+		return nil, nil
+	}
+	if update.Type == "Edit" {
+		_, err := a.API("PATCH", fmt.Sprintf("%v/%v", end, update.ID), map[string]interface{}{
+			"content": strings.TrimSpace(update.Message.Content),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Edit message: %v", err)
+		}
+
+		return update, nil
+	}
+
+	if len(update.Message.Segments) != 0 {
+		return a.pushSegments(update)
+	}
+	if update.Message.Type == "Location" || update.Message.Type == "Contact" {
+		return nil, fmt.Errorf("Send %v: Not supported", strings.ToLower(update.Message.Type))
+	}
+
+	var msg interface{}
+	var err error
+
+	remote := update.Message.Media == nil && isURL(update.Message.Content)
+
+	if Contains([]string{"Image", "Sticker", "Audio", "Video", "Document"}, update.Message.Type) {
+		if remote && (update.Message.Type == "Image" || update.Message.Type == "Sticker") {
+			msg, err = a.API("POST", end, map[string]interface{}{
+				"embeds": []interface{}{
+					map[string]interface{}{
+						"image": map[string]interface{}{
+							"url": update.Message.Content,
+						},
+					},
+				},
+			})
+		} else if remote {
+			msg, err = a.API("POST", end, map[string]interface{}{
+				"content": update.Message.Content,
+			})
+		} else if update.Message.Media != nil {
+			msg, err = a.upload(end, update.Message.Media, map[string]interface{}{})
+		} else {
+			var f *os.File
+			f, err = os.Open(update.Message.Content)
+			if err == nil {
+				msg, err = a.upload(end, ReaderMedia(filepath.Base(update.Message.Content), "", f), map[string]interface{}{})
+				f.Close()
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Send %v: %v", strings.ToLower(update.Message.Type), err)
+		}
+	} else {
+		m := map[string]interface{}{
+			"content": strings.TrimSpace(update.Message.Content),
+		}
+		if update.Message.ReplyTo != nil {
+			m["message_reference"] = map[string]interface{}{
+				"message_id": strconv.FormatInt(update.Message.ReplyTo.ID, 10),
+			}
+		}
+
+		msg, err = a.API("POST", end, m)
+		if err != nil {
+			return nil, fmt.Errorf("Send text message: %v", err)
+		}
+	}
+
+	update.ID = parseSnowflake(msg.(map[string]interface{})["id"])
+
+	return update, nil
+}
+
+// Platform returns a string showing the platform of the bot.
+func (a *APIDiscord) Platform() string {
+	return "Discord"
+}
+
+// ParseUserID parses the ID of the User in the At string.
+func (a *APIDiscord) ParseUserID(u *Update, s string) (int64, error) {
+	if strings.HasPrefix(s, "<@") && strings.HasSuffix(s, ">") {
+		s = strings.TrimPrefix(s[2:len(s)-1], "!")
+
+		id, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("Invalid At string: %v", err)
+		}
+		return id, nil
+	}
+
+	return 0, errors.New("Invalid At string")
+}
+
+func (a *APIDiscord) ats(u *User) []string {
+	return []string{fmt.Sprintf("<@%v>", u.ID), fmt.Sprintf("<@!%v>", u.ID)}
+}
@@ -0,0 +1,161 @@
+package botmaid
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// APITest is an API keeping everything in the memory for testing, the updates
+// injected by Inject are pulled and the pushed updates are recorded.
+type APITest struct {
+	mu      sync.Mutex
+	updates UpdateChannel
+	pushes  []*Update
+	pushed  chan struct{}
+	nextID  int64
+}
+
+func (a *APITest) init() {
+	if a.updates == nil {
+		a.updates = make(UpdateChannel, 100)
+		a.pushed = make(chan struct{}, 1)
+	}
+}
+
+// Inject sends an update to the bot as if it comes from the platform.
+func (a *APITest) Inject(u *Update) {
+	a.mu.Lock()
+	a.init()
+	if u.ID == 0 {
+		a.nextID++
+		u.ID = a.nextID
+	}
+	updates := a.updates
+	a.mu.Unlock()
+
+	updates <- u
+}
+
+// Pushes returns the updates pushed by the bot.
+func (a *APITest) Pushes() []*Update {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return append([]*Update{}, a.pushes...)
+}
+
+// Reset forgets the pushed updates.
+func (a *APITest) Reset() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.pushes = nil
+}
+
+// WaitPushes waits until the bot has pushed at least n updates, and returns
+// the pushed updates.
+func (a *APITest) WaitPushes(n int, timeout time.Duration) ([]*Update, error) {
+	a.mu.Lock()
+	a.init()
+	pushed := a.pushed
+	a.mu.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		if ps := a.Pushes(); len(ps) >= n {
+			return ps, nil
+		}
+
+		select {
+		case <-pushed:
+		case <-timer.C:
+			ps := a.Pushes()
+			return ps, fmt.Errorf("Wait pushes: Got %v of %v updates", len(ps), n)
+		}
+	}
+}
+
+// Pull pulls the injected updates.
+func (a *APITest) Pull(ctx context.Context, pc *PullConfig) (UpdateChannel, ErrorChannel) {
+	a.mu.Lock()
+	a.init()
+	in := a.updates
+	a.mu.Unlock()
+
+	updates := make(UpdateChannel, pc.Limit)
+	errors := make(ErrorChannel, pc.Limit)
+
+	go func() {
+		defer close(updates)
+		defer close(errors)
+
+		for {
+			select {
+			case u := <-in:
+				if !updates.push(ctx, u) {
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return updates, errors
+}
+
+// Push records the update and returns it with a new ID.
+func (a *APITest) Push(u *Update) (*Update, error) {
+	if u.Chat == nil {
+		return nil, errors.New("Push: Missing chat")
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.init()
+
+	a.nextID++
+	uu := *u
+	if uu.Type != "Delete" && uu.Type != "Edit" {
+		uu.ID = a.nextID
+	}
+	if uu.Message != nil {
+		m := *uu.Message
+		m.ID = uu.ID
+		uu.Message = &m
+	}
+	uu.Time = time.Now()
+	a.pushes = append(a.pushes, &uu)
+
+	select {
+	case a.pushed <- struct{}{}:
+	default:
+	}
+
+	return &uu, nil
+}
+
+// Platform returns a string showing the platform of the bot.
+func (a *APITest) Platform() string {
+	return "Test"
+}
+
+// ParseUserID parses the ID of a user like "@123" or "123".
+func (a *APITest) ParseUserID(u *Update, s string) (int64, error) {
+	id, err := strconv.ParseInt(strings.TrimPrefix(s, "@"), 10, 64)
+	if err != nil {
+		return 0, errors.New("Invalid At string")
+	}
+	return id, nil
+}
+
+func (a *APITest) ats(u *User) []string {
+	return []string{fmt.Sprintf("@%v", u.ID)}
+}
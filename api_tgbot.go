@@ -2,15 +2,21 @@ package botmaid
 
 import (
 	"bytes"
+	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"html"
+	"io"
 	"io/ioutil"
 	"mime/multipart"
 	"net/http"
+	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 	"unicode/utf16"
 
@@ -18,27 +24,60 @@ import (
 )
 
 // APITelegramBot is a struct stores some basic information of the Telegram Bot API. Please search in official API document for details.
+//
+// The updates will be received by a webhook server listening on WebhookListen
+// instead of getUpdates if WebhookListen is set, and the webhook will be
+// registered to WebhookURL with WebhookSecret if WebhookURL is set. The
+// certificate WebhookCert is uploaded with the webhook, so that it could be a
+// self-signed one.
 type APITelegramBot struct {
 	Token  string
 	Offset int64
+
+	WebhookURL    string
+	WebhookListen string
+	WebhookPath   string
+	WebhookSecret string
+	WebhookCert   string
+	WebhookKey    string
+
+	Cache *Cache
+
+	mu      sync.Mutex
+	fileIDs map[string]string
+	seen    map[int64]bool
+	seenIDs []int64
 }
 
 const (
-	endPointAPITelegramBot = "https://api.telegram.org/bot%v/%v"
+	endPointAPITelegramBot  = "https://api.telegram.org/bot%v/%v"
+	endPointFileTelegramBot = "https://api.telegram.org/file/bot%v/%v"
 )
 
 // API returns the body of an HTTP response to the Telegram Bot API.
 func (a *APITelegramBot) API(end string, m map[string]interface{}) (interface{}, error) {
-	url := fmt.Sprintf(endPointAPITelegramBot, a.Token, end)
+	return a.APIContext(context.Background(), end, m)
+}
 
+// APIContext is the same as API but the request could be cancelled by the
+// context.
+func (a *APITelegramBot) APIContext(ctx context.Context, end string, m map[string]interface{}) (interface{}, error) {
 	j, err := json.Marshal(m)
+	if err != nil {
+		return nil, fmt.Errorf("API %v: %v", end, err)
+	}
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(j))
+	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf(endPointAPITelegramBot, a.Token, end), bytes.NewBuffer(j))
+	if err != nil {
+		return nil, fmt.Errorf("API %v: %v", end, err)
+	}
 	req.Header.Set("Content-Type", "application/json")
 
-	client := &http.Client{}
+	return a.do(req, end)
+}
 
-	resp, err := client.Do(req)
+func (a *APITelegramBot) do(req *http.Request, end string) (interface{}, error) {
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("API %v: %v", end, err)
 	}
@@ -66,378 +105,1204 @@ func (a *APITelegramBot) API(end string, m map[string]interface{}) (interface{},
 	return ret["result"], nil
 }
 
+// upload calls the method with a multipart form, the file is streamed into
+// the form instead of being read into the memory first.
+func (a *APITelegramBot) upload(end string, fields map[string]string, field, name string, r io.Reader) (interface{}, error) {
+	pr, pw := io.Pipe()
+	w := multipart.NewWriter(pw)
+
+	go func() {
+		for k, v := range fields {
+			err := w.WriteField(k, v)
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+
+		part, err := w.CreateFormFile(field, name)
+		if err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		_, err = io.Copy(part, r)
+		if err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		pw.CloseWithError(w.Close())
+	}()
+
+	req, err := http.NewRequest("POST", fmt.Sprintf(endPointAPITelegramBot, a.Token, end), pr)
+	if err != nil {
+		pr.Close()
+		return nil, fmt.Errorf("API %v: %v", end, err)
+	}
+	req.Header.Set("Content-Type", w.FormDataContentType())
+
+	ret, err := a.do(req, end)
+	pr.Close()
+	return ret, err
+}
+
+func telegramSegments(text string, es []interface{}, update *Update) []*Segment {
+	ss := []*Segment{}
+
+	u16 := utf16.Encode([]rune(text))
+	sub := func(i, j int) string {
+		return string(utf16.Decode(u16[i:j]))
+	}
+
+	last := 0
+	for _, v := range es {
+		e := v.(map[string]interface{})
+		offset := int(e["offset"].(float64))
+		length := int(e["length"].(float64))
+		if offset < last || offset+length > len(u16) {
+			continue
+		}
+
+		var s *Segment
+		switch e["type"].(string) {
+		case "mention":
+			s = MentionSegment(&User{
+				UserName: strings.TrimPrefix(sub(offset, offset+length), "@"),
+				Update:   update,
+			})
+		case "text_mention":
+			u, ok := e["user"].(map[string]interface{})
+			if !ok {
+				continue
+			}
+			s = MentionSegment(&User{
+				ID:       int64(u["id"].(float64)),
+				NickName: sub(offset, offset+length),
+				Update:   update,
+			})
+			if n, ok := u["username"].(string); ok {
+				s.User.UserName = n
+			}
+		case "url":
+			s = LinkSegment(sub(offset, offset+length), sub(offset, offset+length))
+		case "text_link":
+			s = LinkSegment(e["url"].(string), sub(offset, offset+length))
+		default:
+			continue
+		}
+
+		if offset > last {
+			ss = append(ss, TextSegment(sub(last, offset)))
+		}
+		ss = append(ss, s)
+		last = offset + length
+	}
+	if last < len(u16) {
+		ss = append(ss, TextSegment(sub(last, len(u16))))
+	}
+
+	return ss
+}
+
 func (a *APITelegramBot) mapToUpdates(m []interface{}) ([]*Update, error) {
 	us := []*Update{}
 	for _, v := range m {
 		e := v.(map[string]interface{})
 
-		update := &Update{}
+		id := int64(e["update_id"].(float64))
+		if !a.fresh(id) {
+			continue
+		}
 
-		if _, ok := e["message"]; ok {
-			m := e["message"].(map[string]interface{})
-			c := m["chat"].(map[string]interface{})
+		ups := []*Update{}
+		if m, ok := e["my_chat_member"].(map[string]interface{}); ok {
+			ups = a.myChatMemberUpdates(id, m)
+		} else if m, ok := e["chat_join_request"].(map[string]interface{}); ok {
+			ups = a.joinRequestUpdates(id, m)
+		} else if m, ok := e["callback_query"].(map[string]interface{}); ok {
+			ups = a.callbackUpdates(id, m)
+		} else if m, ok := e["inline_query"].(map[string]interface{}); ok {
+			ups = a.inlineUpdates(id, m)
+		} else if m, ok := e["message"].(map[string]interface{}); ok {
+			ups = a.messageUpdates(id, m)
+		} else if m, ok := e["edited_message"].(map[string]interface{}); ok {
+			ups = a.editedMessageUpdates(id, m)
+		}
 
-			if int64(e["update_id"].(float64)) < a.Offset {
-				continue
+		for _, update := range ups {
+			if update.Message != nil {
+				update.Message.Update = update
 			}
-
-			if int64(e["update_id"].(float64))+1 > a.Offset {
-				a.Offset = int64(e["update_id"].(float64)) + 1
+			if update.Chat != nil {
+				update.Chat.Update = update
 			}
+			if update.User != nil {
+				update.User.Update = update
+			}
+			if update.Event != nil && update.Event.Operator != nil {
+				update.Event.Operator.Update = update
+			}
+			us = append(us, update)
+		}
+	}
+	return us, nil
+}
 
-			update = &Update{
-				ID: int64(e["update_id"].(float64)),
+func telegramUser(f map[string]interface{}) *User {
+	u := &User{
+		ID:       int64(f["id"].(float64)),
+		NickName: f["first_name"].(string),
+	}
 
-				Type: "message_text",
+	if _, ok := f["last_name"]; ok {
+		u.NickName += " " + f["last_name"].(string)
+	}
 
-				Time: time.Unix(int64(m["date"].(float64)), 0),
+	if _, ok := f["username"]; ok {
+		u.UserName = f["username"].(string)
+	}
 
-				Chat: &Chat{
-					ID:   int64(c["id"].(float64)),
-					Type: c["type"].(string),
-				},
+	return u
+}
 
-				Message: &Message{
-					ID: int64(m["message_id"].(float64)),
-				},
-			}
+func telegramChat(c map[string]interface{}) *Chat {
+	chat := &Chat{
+		ID:   int64(c["id"].(float64)),
+		Type: c["type"].(string),
+	}
 
-			if _, ok := c["title"]; ok {
-				update.Chat.Title = c["title"].(string)
-			}
+	if _, ok := c["title"]; ok {
+		chat.Title = c["title"].(string)
+	}
 
-			if _, ok := m["text"]; ok {
-				update.Message.Content = m["text"].(string)
-				if _, ok := m["reply_to_message"]; ok {
-					r := m["reply_to_message"].(map[string]interface{})
-					if _, ok := r["from"]; ok {
-						u := r["from"].(map[string]interface{})
-						if _, ok := u["username"]; ok {
-							update.Message.Content = fmt.Sprintf("@%v", r["from"].(map[string]interface{})["username"].(string)) + " " + update.Message.Content
-						}
-					}
-				}
+	return chat
+}
 
-				if _, ok := m["entities"]; ok {
-					es := m["entities"].([]interface{})
-					for i := len(es) - 1; i >= 0; i-- {
-						e := es[i].(map[string]interface{})
-						if e["type"].(string) != "text_mention" {
-							continue
-						}
-
-						if e["user"] != nil {
-							offset := int(e["offset"].(float64))
-							length := int(e["length"].(float64))
-							user := e["user"].(map[string]interface{})
-
-							nickName := user["first_name"].(string)
-							if _, ok := user["last_name"]; ok {
-								nickName += " " + user["last_name"].(string)
-							}
-							strings.ReplaceAll(nickName, "\\", "\\\\")
-							strings.ReplaceAll(nickName, "'", "\\'")
-							strings.ReplaceAll(nickName, "\"", "\\\"")
-
-							u16 := utf16.Encode([]rune(update.Message.Content))
-							t := append(u16[:offset], utf16.Encode([]rune(fmt.Sprintf("\"<a href=\\\"tg://user?id=%v\\\">%v</a>\"", int64(user["id"].(float64)), nickName)))...)
-							u16 = append(t, u16[offset+length:]...)
-							update.Message.Content = string(utf16.Decode(u16))
-						}
-					}
-				}
-			}
+// selfID returns the ID of the bot, which is the first part of the token.
+func (a *APITelegramBot) selfID() int64 {
+	id, _ := strconv.ParseInt(strings.SplitN(a.Token, ":", 2)[0], 10, 64)
+	return id
+}
 
-			if _, ok := m["sticker"]; ok {
-				s := m["sticker"].(map[string]interface{})
-				if _, ok := s["emoji"]; ok {
-					update.Message.Content = s["emoji"].(string)
-				}
-			}
+func (a *APITelegramBot) myChatMemberUpdates(id int64, m map[string]interface{}) []*Update {
+	update := &Update{
+		ID:    id,
+		Time:  time.Unix(int64(m["date"].(float64)), 0),
+		Chat:  telegramChat(m["chat"].(map[string]interface{})),
+		User:  telegramUser(m["from"].(map[string]interface{})),
+		Event: &Event{},
+	}
 
-			if _, ok := m["from"]; ok {
-				f := m["from"].(map[string]interface{})
+	n := m["new_chat_member"].(map[string]interface{})
+	switch n["status"] {
+	case "member", "administrator", "creator", "restricted":
+		update.Type = "bot_added"
+	case "left", "kicked":
+		update.Type = "bot_removed"
+	default:
+		return nil
+	}
 
-				update.User = &User{
-					ID:       int64(f["id"].(float64)),
-					NickName: f["first_name"].(string),
-				}
+	o := m["old_chat_member"].(map[string]interface{})
+	if (update.Type == "bot_added") == Contains([]string{"member", "administrator", "creator", "restricted"}, o["status"]) {
+		// The permissions of the bot are changed.
+		return nil
+	}
 
-				if _, ok := f["last_name"]; ok {
-					update.User.NickName += " " + f["last_name"].(string)
-				}
+	a.Cache.InvalidateChat(a.Platform(), update.Chat.ID)
+	return []*Update{update}
+}
 
-				if _, ok := f["username"]; ok {
-					update.User.UserName = f["username"].(string)
-				}
+func (a *APITelegramBot) callbackUpdates(id int64, m map[string]interface{}) []*Update {
+	update := &Update{
+		ID:   id,
+		Type: "callback_query",
+		Time: time.Now(),
+		User: telegramUser(m["from"].(map[string]interface{})),
+		Callback: &Callback{
+			ID: m["id"].(string),
+		},
+	}
+
+	if s, ok := m["data"].(string); ok {
+		update.Callback.Data = s
+	}
+	if msg, ok := m["message"].(map[string]interface{}); ok {
+		update.Chat = telegramChat(msg["chat"].(map[string]interface{}))
+		update.Callback.MessageID = int64(msg["message_id"].(float64))
+	}
+
+	return []*Update{update}
+}
+
+func telegramReplyMarkup(bs [][]*Button) map[string]interface{} {
+	rows := []interface{}{}
+	for _, row := range bs {
+		r := []interface{}{}
+		for _, b := range row {
+			if b.URL != "" {
+				r = append(r, map[string]interface{}{
+					"text": b.Text,
+					"url":  b.URL,
+				})
+				continue
 			}
+
+			r = append(r, map[string]interface{}{
+				"text":          b.Text,
+				"callback_data": b.Data,
+			})
 		}
+		rows = append(rows, r)
+	}
+
+	return map[string]interface{}{
+		"inline_keyboard": rows,
+	}
+}
+
+func (a *APITelegramBot) answerCallback(u *Update, text string, alert bool) error {
+	m := map[string]interface{}{
+		"callback_query_id": u.Callback.ID,
+	}
+	if text != "" {
+		m["text"] = text
+		m["show_alert"] = alert
+	}
+
+	_, err := a.API("answerCallbackQuery", m)
+	if err != nil {
+		return fmt.Errorf("Answer callback: %v", err)
+	}
+	return nil
+}
+
+func (a *APITelegramBot) inlineUpdates(id int64, m map[string]interface{}) []*Update {
+	update := &Update{
+		ID:   id,
+		Type: "inline_query",
+		Time: time.Now(),
+		User: telegramUser(m["from"].(map[string]interface{})),
+		Inline: &Inline{
+			ID:    m["id"].(string),
+			Query: m["query"].(string),
+		},
+	}
+
+	if s, ok := m["offset"].(string); ok {
+		update.Inline.Offset = s
+	}
+
+	return []*Update{update}
+}
+
+func telegramInlineResult(i int, r *InlineResult) (map[string]interface{}, error) {
+	m := map[string]interface{}{
+		"type": r.Type,
+		"id":   r.ID,
+	}
+	if r.ID == "" {
+		m["id"] = strconv.Itoa(i)
+	}
+	if len(r.Buttons) != 0 {
+		m["reply_markup"] = telegramReplyMarkup(r.Buttons)
+	}
 
-		if update.Message != nil {
-			update.Message.Update = update
+	switch r.Type {
+	case "article":
+		m["title"] = r.Title
+		m["input_message_content"] = map[string]interface{}{
+			"message_text": r.Content,
+			"parse_mode":   "HTML",
+		}
+		if r.Description != "" {
+			m["description"] = r.Description
+		}
+		if r.URL != "" {
+			m["url"] = r.URL
+		}
+		if r.ThumbURL != "" {
+			m["thumb_url"] = r.ThumbURL
+		}
+	case "photo":
+		if r.FileID != "" {
+			m["photo_file_id"] = r.FileID
+		} else {
+			m["photo_url"] = r.URL
+			m["thumb_url"] = r.URL
+			if r.ThumbURL != "" {
+				m["thumb_url"] = r.ThumbURL
+			}
 		}
-		if update.Chat != nil {
-			update.Chat.Update = update
+		if r.Title != "" {
+			m["title"] = r.Title
 		}
-		if update.User != nil {
-			update.User.Update = update
+		if r.Description != "" {
+			m["description"] = r.Description
 		}
-		us = append(us, update)
+		if r.Content != "" {
+			m["caption"] = r.Content
+			m["parse_mode"] = "HTML"
+		}
+	case "sticker":
+		m["sticker_file_id"] = r.FileID
+	default:
+		return nil, fmt.Errorf("Unknown result type %v", r.Type)
 	}
-	return us, nil
+
+	return m, nil
 }
 
-// Pull pulls updates and errors into the channels with a given config.
-func (a *APITelegramBot) Pull(pc *PullConfig) (UpdateChannel, ErrorChannel) {
-	updates := make(chan *Update)
-	errors := make(chan error)
+func (a *APITelegramBot) answerInline(u *Update, ans *InlineAnswer) error {
+	rs := []interface{}{}
+	for i, r := range ans.Results {
+		m, err := telegramInlineResult(i, r)
+		if err != nil {
+			return fmt.Errorf("Answer inline: %v", err)
+		}
+		rs = append(rs, m)
+	}
 
-	go func() {
-		for {
-			m, err := a.API("getUpdates", map[string]interface{}{
-				"limit":   pc.Limit,
-				"timeout": pc.Timeout,
-				"offset":  a.Offset,
-			})
-			if err != nil {
-				errors <- err
-				time.Sleep(pc.RetryWaitingTime)
-				continue
-			}
-			us, err := a.mapToUpdates(m.([]interface{}))
-			if err != nil {
-				errors <- err
-				time.Sleep(pc.RetryWaitingTime)
-				continue
-			}
-			for _, u := range us {
-				updates <- u
-			}
+	m := map[string]interface{}{
+		"inline_query_id": u.Inline.ID,
+		"results":         rs,
+		"next_offset":     ans.NextOffset,
+		"is_personal":     ans.Personal,
+	}
+	if ans.CacheTime > 0 {
+		m["cache_time"] = int64(ans.CacheTime / time.Second)
+	}
+
+	_, err := a.API("answerInlineQuery", m)
+	if err != nil {
+		return fmt.Errorf("Answer inline: %v", err)
+	}
+	return nil
+}
+
+func (a *APITelegramBot) joinRequestUpdates(id int64, m map[string]interface{}) []*Update {
+	update := &Update{
+		ID:    id,
+		Type:  "join_request",
+		Time:  time.Unix(int64(m["date"].(float64)), 0),
+		Chat:  telegramChat(m["chat"].(map[string]interface{})),
+		User:  telegramUser(m["from"].(map[string]interface{})),
+		Event: &Event{},
+	}
+
+	if s, ok := m["bio"].(string); ok {
+		update.Event.Comment = s
+	}
+
+	return []*Update{update}
+}
+
+// memberUpdates returns the updates of the members joining or leaving, the
+// ones of the bot itself are returned by myChatMemberUpdates.
+func (a *APITelegramBot) memberUpdates(id int64, m map[string]interface{}) []*Update {
+	ups := []*Update{}
+
+	chat := telegramChat(m["chat"].(map[string]interface{}))
+	from := telegramUser(m["from"].(map[string]interface{}))
+
+	add := func(t string, u *User) {
+		a.Cache.Invalidate(CacheMemberKey(a.Platform(), chat.ID, u.ID))
+		if u.ID == a.selfID() {
+			return
 		}
-	}()
 
-	return updates, errors
+		c := *chat
+		update := &Update{
+			ID:    id,
+			Type:  t,
+			Time:  time.Unix(int64(m["date"].(float64)), 0),
+			Chat:  &c,
+			User:  u,
+			Event: &Event{},
+		}
+		if from.ID != u.ID {
+			o := *from
+			update.Event.Operator = &o
+		}
+		ups = append(ups, update)
+	}
+
+	if us, ok := m["new_chat_members"].([]interface{}); ok {
+		for _, v := range us {
+			add("member_join", telegramUser(v.(map[string]interface{})))
+		}
+	}
+	if u, ok := m["left_chat_member"].(map[string]interface{}); ok {
+		add("member_leave", telegramUser(u))
+	}
+
+	return ups
 }
 
-// Push pushes an update and returns it back if existing.
-func (a *APITelegramBot) Push(update *Update) (*Update, error) {
-	if update.Type == "Delete" {
-		_, err := a.API("deleteMessage", map[string]interface{}{
-			"chat_id":    update.Chat.ID,
-			"message_id": update.ID,
-		})
-		if err != nil {
-			return nil, fmt.Errorf("Delete message: %v", err)
+func (a *APITelegramBot) editedMessageUpdates(id int64, m map[string]interface{}) []*Update {
+	ups := a.messageUpdates(id, m)
+	for _, update := range ups {
+		update.Type = "message_edited"
+		if d, ok := m["edit_date"].(float64); ok {
+			update.Time = time.Unix(int64(d), 0)
 		}
+	}
+	return ups
+}
 
-		return nil, nil
+func (a *APITelegramBot) messageUpdates(id int64, m map[string]interface{}) []*Update {
+	_, join := m["new_chat_members"]
+	_, leave := m["left_chat_member"]
+	if join || leave {
+		return a.memberUpdates(id, m)
 	}
 
-	if update.Message.Type == "Image" && strings.HasSuffix(update.Message.Content, ".gif") {
-		method := fmt.Sprintf(endPointAPITelegramBot, a.Token, "sendAnimation")
+	c := m["chat"].(map[string]interface{})
 
-		buf := new(bytes.Buffer)
-		w := multipart.NewWriter(buf)
+	update := &Update{
+		ID: id,
 
-		ct := "multipart/form-data; boundary=" + w.Boundary()
+		Type: "message_text",
 
-		_ = w.WriteField("chat_id", strconv.FormatInt(update.Chat.ID, 10))
+		Time: time.Unix(int64(m["date"].(float64)), 0),
 
-		file, err := ioutil.ReadFile(update.Message.Content)
-		if err != nil {
-			return nil, fmt.Errorf("Send image: API %v: %v", "sendAnimation", err)
+		Chat: &Chat{
+			ID:   int64(c["id"].(float64)),
+			Type: c["type"].(string),
+		},
+
+		Message: &Message{
+			ID: int64(m["message_id"].(float64)),
+		},
+	}
+
+	if _, ok := m["new_chat_title"]; ok {
+		a.Cache.InvalidateChat(a.Platform(), update.Chat.ID)
+	}
+	if _, ok := c["title"]; ok {
+		update.Chat.Title = c["title"].(string)
+		a.Cache.Set(CacheChatKey(a.Platform(), update.Chat.ID), update.Chat.Title)
+	}
+
+	if r, ok := m["reply_to_message"].(map[string]interface{}); ok {
+		update.Message.Segments = append(update.Message.Segments, &Segment{
+			Type: "Reply",
+			ID:   int64(r["message_id"].(float64)),
+		})
+
+		update.Message.ReplyTo = &ReplyTo{
+			ID: int64(r["message_id"].(float64)),
+		}
+		if f, ok := r["from"].(map[string]interface{}); ok {
+			update.Message.ReplyTo.User = telegramUser(f)
+			update.Message.ReplyTo.User.Update = update
 		}
+		if s, ok := r["text"].(string); ok {
+			update.Message.ReplyTo.Content = replyExcerpt(s)
+		} else if s, ok := r["caption"].(string); ok {
+			update.Message.ReplyTo.Content = replyExcerpt(s)
+		}
+	}
 
-		part, err := w.CreateFormFile("animation", filepath.Base(update.Message.Content))
-		if err != nil {
-			return nil, fmt.Errorf("Send image: API %v: %v", "sendAnimation", err)
+	if _, ok := m["text"]; ok {
+		es, _ := m["entities"].([]interface{})
+		update.Message.Segments = append(update.Message.Segments, telegramSegments(m["text"].(string), es, update)...)
+
+		update.Message.Content = m["text"].(string)
+
+		if _, ok := m["entities"]; ok {
+			es := m["entities"].([]interface{})
+			for i := len(es) - 1; i >= 0; i-- {
+				e := es[i].(map[string]interface{})
+				if e["type"].(string) != "text_mention" {
+					continue
+				}
+
+				if e["user"] != nil {
+					offset := int(e["offset"].(float64))
+					length := int(e["length"].(float64))
+					user := e["user"].(map[string]interface{})
+
+					nickName := user["first_name"].(string)
+					if _, ok := user["last_name"]; ok {
+						nickName += " " + user["last_name"].(string)
+					}
+					strings.ReplaceAll(nickName, "\\", "\\\\")
+					strings.ReplaceAll(nickName, "'", "\\'")
+					strings.ReplaceAll(nickName, "\"", "\\\"")
+
+					u16 := utf16.Encode([]rune(update.Message.Content))
+					t := append(u16[:offset], utf16.Encode([]rune(fmt.Sprintf("\"<a href=\\\"tg://user?id=%v\\\">%v</a>\"", int64(user["id"].(float64)), nickName)))...)
+					u16 = append(t, u16[offset+length:]...)
+					update.Message.Content = string(utf16.Decode(u16))
+				}
+			}
 		}
+	}
 
-		part.Write(file)
-		w.Close()
+	if _, ok := m["sticker"]; ok {
+		s := m["sticker"].(map[string]interface{})
+		if _, ok := s["emoji"]; ok {
+			update.Message.Content = s["emoji"].(string)
+		}
 
-		header := http.Header{}
-		header.Add("Content-Type", ct)
+		update.Message.Segments = append(update.Message.Segments, &Segment{
+			Type: "Sticker",
+			Text: update.Message.Content,
+			File: s["file_id"].(string),
+		})
+		update.Message.Attachments = append(update.Message.Attachments, telegramAttachment("Sticker", "image/webp", s, update))
+	}
 
-		req, err := http.NewRequest("POST", method, buf)
-		if err != nil {
-			return nil, fmt.Errorf("Send image: API %v: %v", "sendAnimation", err)
+	if ps, ok := m["photo"].([]interface{}); ok && len(ps) != 0 {
+		p := ps[len(ps)-1].(map[string]interface{})
+		update.Message.Segments = append(update.Message.Segments, ImageSegment(p["file_id"].(string)))
+		update.Message.Attachments = append(update.Message.Attachments, telegramAttachment("Image", "image/jpeg", p, update))
+	}
+	if v, ok := m["voice"].(map[string]interface{}); ok {
+		update.Message.Segments = append(update.Message.Segments, AudioSegment(v["file_id"].(string)))
+		update.Message.Attachments = append(update.Message.Attachments, telegramAttachment("Audio", "audio/ogg", v, update))
+	}
+	if v, ok := m["audio"].(map[string]interface{}); ok {
+		update.Message.Segments = append(update.Message.Segments, AudioSegment(v["file_id"].(string)))
+		update.Message.Attachments = append(update.Message.Attachments, telegramAttachment("Audio", "audio/mpeg", v, update))
+	}
+	if v, ok := m["video"].(map[string]interface{}); ok {
+		update.Message.Attachments = append(update.Message.Attachments, telegramAttachment("Video", "video/mp4", v, update))
+	}
+	if d, ok := m["document"].(map[string]interface{}); ok {
+		update.Message.Attachments = append(update.Message.Attachments, telegramAttachment("Document", "application/octet-stream", d, update))
+	}
+	if l, ok := m["location"].(map[string]interface{}); ok {
+		update.Message.Location = &Location{}
+		update.Message.Location.Latitude, _ = l["latitude"].(float64)
+		update.Message.Location.Longitude, _ = l["longitude"].(float64)
+		if v, ok := m["venue"].(map[string]interface{}); ok {
+			update.Message.Location.Title, _ = v["title"].(string)
+			update.Message.Location.Address, _ = v["address"].(string)
+		}
+	}
+	if c, ok := m["contact"].(map[string]interface{}); ok {
+		update.Message.Contact = &Contact{}
+		update.Message.Contact.Phone, _ = c["phone_number"].(string)
+		update.Message.Contact.Name, _ = c["first_name"].(string)
+		if s, ok := c["last_name"].(string); ok {
+			update.Message.Contact.Name += " " + s
 		}
-		req.Header = header
+		if id, ok := c["user_id"].(float64); ok {
+			update.Message.Contact.UserID = int64(id)
+		}
+	}
 
-		resp, err := http.DefaultClient.Do(req)
-		if err != nil {
-			return nil, fmt.Errorf("Send image: API %v: %v", "sendAnimation", err)
+	if s, ok := m["caption"].(string); ok {
+		es, _ := m["caption_entities"].([]interface{})
+		update.Message.Segments = append(update.Message.Segments, telegramSegments(s, es, update)...)
+		update.Message.Content = s
+	}
+
+	if _, ok := m["from"]; ok {
+		update.User = telegramUser(m["from"].(map[string]interface{}))
+	}
+
+	return []*Update{update}
+}
+
+// telegramAttachment returns the attachment of a file, mime is used if the
+// file does not have one.
+func telegramAttachment(t, mime string, f map[string]interface{}, update *Update) *Attachment {
+	at := &Attachment{
+		Type:   t,
+		FileID: f["file_id"].(string),
+		MIME:   mime,
+		Update: update,
+	}
+
+	if s, ok := f["mime_type"].(string); ok {
+		at.MIME = s
+	}
+	if s, ok := f["file_name"].(string); ok {
+		at.Name = s
+	}
+	if i, ok := f["file_size"].(float64); ok {
+		at.Size = int64(i)
+	}
+
+	return at
+}
+
+func (a *APITelegramBot) webhookHandler(ctx context.Context, updates UpdateChannel, errors ErrorChannel) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		if a.WebhookSecret != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Telegram-Bot-Api-Secret-Token")), []byte(a.WebhookSecret)) != 1 {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
 		}
-		defer resp.Body.Close()
 
-		raw, err := ioutil.ReadAll(resp.Body)
+		raw, err := ioutil.ReadAll(r.Body)
 		if err != nil {
-			return nil, fmt.Errorf("Send image: API %v: %v", "sendAnimation", err)
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
 		}
 
 		m := map[string]interface{}{}
 		err = json.Unmarshal(raw, &m)
 		if err != nil {
-			return nil, fmt.Errorf("Send image: API %v: %v", "sendAnimation", err)
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			errors.push(ctx, fmt.Errorf("Webhook: %v", err))
+			return
 		}
 
-		if _, ok := m["ok"]; !ok {
-			return nil, fmt.Errorf("Send image: API %v: Unsuccessful request", "sendAnimation")
+		a.mu.Lock()
+		us, err := a.mapToUpdates([]interface{}{m})
+		a.mu.Unlock()
+		if err != nil {
+			errors.push(ctx, fmt.Errorf("Webhook: %v", err))
+			return
 		}
 
-		if !m["ok"].(bool) {
-			return nil, fmt.Errorf("Send image: API %v: %v", "sendAnimation", m["description"].(string))
+		for _, u := range us {
+			if !updates.push(ctx, u) {
+				http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+				return
+			}
 		}
+	}
+}
 
-		update.ID = int64(m["result"].(map[string]interface{})["message_id"].(float64))
+// telegramWebhookSeen is the number of the IDs of the updates remembered by
+// the webhook to drop the updates delivered again.
+const telegramWebhookSeen = 1000
+
+// fresh checks if the update with the ID has not been pulled. The updates of
+// the webhook may come in any order, so the IDs of the latest ones are
+// remembered instead of the offset.
+func (a *APITelegramBot) fresh(id int64) bool {
+	if a.WebhookListen == "" {
+		if id < a.Offset {
+			return false
+		}
+		a.Offset = id + 1
+		return true
+	}
 
-		return update, nil
+	if a.seen == nil {
+		a.seen = map[int64]bool{}
+	}
+	if a.seen[id] {
+		return false
 	}
+	a.seen[id] = true
+	a.seenIDs = append(a.seenIDs, id)
+	if len(a.seenIDs) > telegramWebhookSeen {
+		delete(a.seen, a.seenIDs[0])
+		a.seenIDs = a.seenIDs[1:]
+	}
+	return true
+}
 
-	if update.Message.Type == "Image" || update.Message.Type == "Sticker" {
-		api := "sendPhoto"
-		para := "photo"
-		if update.Message.Type == "Sticker" {
-			api = "sendSticker"
-			para = "sticker"
+// setWebhook registers the webhook, the certificate is uploaded if it is set
+// so that a self-signed one could be used.
+func (a *APITelegramBot) setWebhook(ctx context.Context) error {
+	if a.WebhookCert == "" {
+		m := map[string]interface{}{
+			"url": a.WebhookURL,
+		}
+		if a.WebhookSecret != "" {
+			m["secret_token"] = a.WebhookSecret
 		}
 
-		method := fmt.Sprintf(endPointAPITelegramBot, a.Token, api)
+		_, err := a.APIContext(ctx, "setWebhook", m)
+		return err
+	}
 
-		buf := new(bytes.Buffer)
-		w := multipart.NewWriter(buf)
+	f, err := os.Open(a.WebhookCert)
+	if err != nil {
+		return fmt.Errorf("API setWebhook: %v", err)
+	}
+	defer f.Close()
 
-		ct := "multipart/form-data; boundary=" + w.Boundary()
+	fields := map[string]string{
+		"url": a.WebhookURL,
+	}
+	if a.WebhookSecret != "" {
+		fields["secret_token"] = a.WebhookSecret
+	}
 
-		w.WriteField("chat_id", strconv.FormatInt(update.Chat.ID, 10))
+	_, err = a.upload("setWebhook", fields, "certificate", filepath.Base(a.WebhookCert), f)
+	return err
+}
 
-		if strings.HasPrefix(update.Message.Content, "http://") || strings.HasPrefix(update.Message.Content, "https://") {
-			w.WriteField(para, update.Message.Content)
-		} else {
-			file, err := ioutil.ReadFile(update.Message.Content)
-			if err != nil {
-				return nil, fmt.Errorf("Send image: API %v: %v", api, err)
+func (a *APITelegramBot) pullWebhook(ctx context.Context, pc *PullConfig) (UpdateChannel, ErrorChannel) {
+	updates := make(UpdateChannel)
+	errors := make(ErrorChannel)
+
+	path := a.WebhookPath
+	if path == "" {
+		path = "/"
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(path, a.webhookHandler(ctx, updates, errors))
+
+	server := &http.Server{
+		Addr:    a.WebhookListen,
+		Handler: mux,
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		<-ctx.Done()
+		server.Shutdown(context.Background())
+		close(closed)
+	}()
+
+	go func() {
+		defer close(updates)
+		defer close(errors)
+
+		if a.WebhookURL != "" {
+			for {
+				err := a.setWebhook(ctx)
+				if ctx.Err() != nil {
+					<-closed
+					return
+				}
+				if err == nil {
+					break
+				}
+				if !errors.push(ctx, err) || !sleepContext(ctx, pc.RetryWaitingTime) {
+					<-closed
+					return
+				}
 			}
+		}
+
+		for {
+			var err error
+			if a.WebhookCert != "" {
+				err = server.ListenAndServeTLS(a.WebhookCert, a.WebhookKey)
+			} else {
+				err = server.ListenAndServe()
+			}
+			if err == http.ErrServerClosed || ctx.Err() != nil {
+				break
+			}
+			if !errors.push(ctx, fmt.Errorf("Webhook: %v", err)) || !sleepContext(ctx, pc.RetryWaitingTime) {
+				break
+			}
+		}
+
+		<-closed
+	}()
+
+	return updates, errors
+}
+
+// Pull pulls updates and errors into the channels with a given config.
+func (a *APITelegramBot) Pull(ctx context.Context, pc *PullConfig) (UpdateChannel, ErrorChannel) {
+	if a.WebhookListen != "" {
+		return a.pullWebhook(ctx, pc)
+	}
 
-			part, err := w.CreateFormFile(para, filepath.Base(update.Message.Content))
+	updates := make(UpdateChannel)
+	errors := make(ErrorChannel)
+
+	go func() {
+		defer close(updates)
+		defer close(errors)
+
+		for {
+			m, err := a.APIContext(ctx, "getUpdates", map[string]interface{}{
+				"limit":   pc.Limit,
+				"timeout": pc.Timeout,
+				"offset":  a.Offset,
+			})
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				if !errors.push(ctx, err) || !sleepContext(ctx, pc.RetryWaitingTime) {
+					return
+				}
+				continue
+			}
+			us, err := a.mapToUpdates(m.([]interface{}))
 			if err != nil {
-				return nil, fmt.Errorf("Send image: API %v: %v", api, err)
+				if !errors.push(ctx, err) || !sleepContext(ctx, pc.RetryWaitingTime) {
+					return
+				}
+				continue
+			}
+			for _, u := range us {
+				if !updates.push(ctx, u) {
+					return
+				}
 			}
-			part.Write(file)
 		}
-		w.Close()
+	}()
 
-		header := http.Header{}
-		header.Add("Content-Type", ct)
+	return updates, errors
+}
 
-		req, err := http.NewRequest("POST", method, buf)
-		if err != nil {
-			return nil, fmt.Errorf("Send image: API %v: %v", api, err)
+func (a *APITelegramBot) pushSegments(update *Update) (*Update, error) {
+	text := ""
+	replyTo := int64(0)
+	if update.Message.ReplyTo != nil {
+		replyTo = update.Message.ReplyTo.ID
+	}
+	media := []*Segment{}
+
+	for _, s := range update.Message.Segments {
+		switch s.Type {
+		case "Text", "Emoji":
+			text += html.EscapeString(s.Text)
+		case "Mention":
+			if s.User.ID == 0 && s.User.UserName != "" {
+				text += html.EscapeString("@" + s.User.UserName)
+			} else {
+				text += fmt.Sprintf("<a href=\"tg://user?id=%v\">%v</a>", s.User.ID, html.EscapeString(mentionName(s.User)))
+			}
+		case "Link":
+			title := s.Text
+			if title == "" {
+				title = s.URL
+			}
+			text += fmt.Sprintf("<a href=\"%v\">%v</a>", html.EscapeString(s.URL), html.EscapeString(title))
+		case "Reply":
+			replyTo = s.ID
+		case "Image", "Audio", "Sticker":
+			media = append(media, s)
+		default:
+			return nil, fmt.Errorf("Send message: Unknown type of segment %v", s.Type)
 		}
-		req.Header = header
+	}
 
-		resp, err := http.DefaultClient.Do(req)
-		if err != nil {
-			return nil, fmt.Errorf("Send image: API %v: %v", api, err)
+	var ret *Update
+
+	if strings.TrimSpace(text) != "" {
+		m := map[string]interface{}{
+			"chat_id":    update.Chat.ID,
+			"text":       strings.TrimSpace(text),
+			"parse_mode": "HTML",
+		}
+		if replyTo != 0 {
+			m["reply_to_message_id"] = replyTo
+		}
+		if len(update.Message.Buttons) != 0 {
+			m["reply_markup"] = telegramReplyMarkup(update.Message.Buttons)
 		}
-		defer resp.Body.Close()
 
-		raw, err := ioutil.ReadAll(resp.Body)
+		msg, err := a.API("sendMessage", m)
 		if err != nil {
-			return nil, fmt.Errorf("Send image: API %v: %v", api, err)
+			return nil, fmt.Errorf("Send text message: %v", err)
 		}
 
-		m := map[string]interface{}{}
-		err = json.Unmarshal(raw, &m)
-		if err != nil {
-			return nil, fmt.Errorf("Send image: API %v: %v", api, err)
+		update.ID = int64(msg.(map[string]interface{})["message_id"].(float64))
+		ret = update
+	}
+
+	sentText := ret != nil
+	for i, s := range media {
+		m := &Message{
+			Type:    s.Type,
+			Content: s.File,
+		}
+		if !sentText && i == len(media)-1 {
+			// The buttons go with the last media if there is no text.
+			m.Buttons = update.Message.Buttons
 		}
 
-		if _, ok := m["ok"]; !ok {
-			return nil, fmt.Errorf("Send image: API %v: Unsuccessful request", api)
+		u, err := a.Push(&Update{
+			Chat:    update.Chat,
+			Message: m,
+		})
+		if err != nil {
+			// The update is returned with the error if the text or some of the
+			// media have been sent.
+			return ret, err
 		}
 
-		if !m["ok"].(bool) {
-			return nil, fmt.Errorf("Send image: API %v: %v", api, m["description"].(string))
+		if ret == nil {
+			update.ID = u.ID
+			ret = update
 		}
+	}
 
-		update.ID = int64(m["result"].(map[string]interface{})["message_id"].(float64))
+	return ret, nil
+}
 
-		return update, nil
+// edit edits the text of a message, or the caption of a message of media.
+func (a *APITelegramBot) edit(update *Update) (*Update, error) {
+	end, key := "editMessageText", "text"
+	if Contains([]string{"Image", "Audio", "Sticker", "Video", "Document"}, update.Message.Type) {
+		end, key = "editMessageCaption", "caption"
 	}
 
-	if update.Message.Type == "Audio" {
-		method := fmt.Sprintf(endPointAPITelegramBot, a.Token, "sendVoice")
+	m := map[string]interface{}{
+		"chat_id":    update.Chat.ID,
+		"message_id": update.ID,
+		key:          strings.TrimSpace(update.Message.Content),
+		"parse_mode": "HTML",
+	}
+	if len(update.Message.Buttons) != 0 {
+		m["reply_markup"] = telegramReplyMarkup(update.Message.Buttons)
+	}
 
-		buf := new(bytes.Buffer)
-		w := multipart.NewWriter(buf)
+	_, err := a.API(end, m)
+	if err != nil {
+		return nil, fmt.Errorf("Edit message: %v", err)
+	}
+
+	return update, nil
+}
 
-		ct := "multipart/form-data; boundary=" + w.Boundary()
+func telegramIsGIF(m *Message) bool {
+	if m.Media != nil {
+		return m.Media.mime() == "image/gif"
+	}
+	return strings.HasSuffix(m.Content, ".gif")
+}
 
-		w.WriteField("chat_id", strconv.FormatInt(update.Chat.ID, 10))
+// telegramFileID returns the file ID of the file sent in the message.
+func telegramFileID(msg interface{}, field string) string {
+	m, _ := msg.(map[string]interface{})
+	f := m[field]
+	if fs, ok := f.([]interface{}); ok && len(fs) != 0 {
+		f = fs[len(fs)-1]
+	}
+	if f == nil {
+		// Animations may be sent as documents.
+		f = m["document"]
+	}
 
-		if strings.HasPrefix(update.Message.Content, "http://") || strings.HasPrefix(update.Message.Content, "https://") {
-			w.WriteField("voice", update.Message.Content)
-		} else {
-			file, err := ioutil.ReadFile(update.Message.Content)
-			if err != nil {
-				return nil, fmt.Errorf("Send audio: API %v: %v", "sendVoice", err)
-			}
+	fm, _ := f.(map[string]interface{})
+	id, _ := fm["file_id"].(string)
+	return id
+}
 
-			part, err := w.CreateFormFile("voice", filepath.Base(update.Message.Content))
-			if err != nil {
-				return nil, fmt.Errorf("Send audio: API %v: %v", "sendVoice", err)
-			}
+// sendFile sends the file of the message by the method, which is the media
+// of the message, or a URL, a path or a file ID in the content. The content is
+// taken as a file ID if it is neither a URL nor an existing path. The file IDs
+// of the files uploaded are remembered, so that the same files are not
+// uploaded again.
+func (a *APITelegramBot) sendFile(end, field string, update *Update) (*Update, error) {
+	t := strings.ToLower(update.Message.Type)
 
-			part.Write(file)
-			w.Close()
+	fields := map[string]string{
+		"chat_id": strconv.FormatInt(update.Chat.ID, 10),
+	}
+	if update.Message.ReplyTo != nil {
+		fields["reply_to_message_id"] = strconv.FormatInt(update.Message.ReplyTo.ID, 10)
+	}
+	if len(update.Message.Buttons) != 0 {
+		j, err := json.Marshal(telegramReplyMarkup(update.Message.Buttons))
+		if err != nil {
+			return nil, fmt.Errorf("Send %v: %v", t, err)
 		}
+		fields["reply_markup"] = string(j)
+	}
 
-		header := http.Header{}
-		header.Add("Content-Type", ct)
+	send := func(file string) (interface{}, error) {
+		m := map[string]interface{}{}
+		for k, v := range fields {
+			m[k] = v
+		}
+		m[field] = file
+		return a.API(end, m)
+	}
 
-		req, err := http.NewRequest("POST", method, buf)
+	if update.Message.Media == nil && (isURL(update.Message.Content) || isFileID(update.Message.Content)) {
+		msg, err := send(update.Message.Content)
 		if err != nil {
-			return nil, fmt.Errorf("Send image: API %v: %v", "sendVoice", err)
+			return nil, fmt.Errorf("Send %v: %v", t, err)
 		}
-		req.Header = header
 
-		resp, err := http.DefaultClient.Do(req)
-		if err != nil {
-			return nil, fmt.Errorf("Send image: API %v: %v", "sendVoice", err)
+		update.ID = int64(msg.(map[string]interface{})["message_id"].(float64))
+		return update, nil
+	}
+
+	key := ""
+	if update.Message.Media != nil {
+		key = update.Message.Media.key()
+	} else {
+		key = fileKey(update.Message.Content)
+	}
+
+	a.mu.Lock()
+	id, ok := a.fileIDs[key]
+	a.mu.Unlock()
+	if key != "" && ok {
+		msg, err := send(id)
+		if err == nil {
+			update.ID = int64(msg.(map[string]interface{})["message_id"].(float64))
+			return update, nil
 		}
-		defer resp.Body.Close()
 
-		raw, err := ioutil.ReadAll(resp.Body)
+		// The file ID may be expired, so the file is uploaded again.
+		a.mu.Lock()
+		delete(a.fileIDs, key)
+		a.mu.Unlock()
+	}
+
+	var r io.Reader
+	name := ""
+	if update.Message.Media != nil {
+		r = update.Message.Media.reader()
+		name = update.Message.Media.Name
+	} else {
+		f, err := os.Open(update.Message.Content)
 		if err != nil {
-			return nil, fmt.Errorf("Send image: API %v: %v", "sendVoice", err)
+			return nil, fmt.Errorf("Send %v: %v", t, err)
 		}
+		defer f.Close()
+		r = f
+		name = filepath.Base(update.Message.Content)
+	}
+	if name == "" {
+		name = field
+	}
 
-		m := map[string]interface{}{}
-		err = json.Unmarshal(raw, &m)
-		if err != nil {
-			return nil, fmt.Errorf("Send image: API %v: %v", "sendVoice", err)
+	msg, err := a.upload(end, fields, field, name, r)
+	if err != nil {
+		return nil, fmt.Errorf("Send %v: %v", t, err)
+	}
+
+	if id := telegramFileID(msg, field); key != "" && id != "" {
+		a.mu.Lock()
+		if a.fileIDs == nil {
+			a.fileIDs = map[string]string{}
 		}
+		a.fileIDs[key] = id
+		a.mu.Unlock()
+	}
+
+	update.ID = int64(msg.(map[string]interface{})["message_id"].(float64))
+	return update, nil
+}
 
-		if _, ok := m["ok"]; !ok {
-			return nil, fmt.Errorf("Send image: API %v: Unsuccessful request", "sendVoice")
+// send calls the method with the chat, the message replied and the buttons of
+// the update.
+func (a *APITelegramBot) send(end string, update *Update, m map[string]interface{}) (*Update, error) {
+	m["chat_id"] = update.Chat.ID
+	if update.Message.ReplyTo != nil {
+		m["reply_to_message_id"] = update.Message.ReplyTo.ID
+	}
+	if len(update.Message.Buttons) != 0 {
+		m["reply_markup"] = telegramReplyMarkup(update.Message.Buttons)
+	}
+
+	msg, err := a.API(end, m)
+	if err != nil {
+		return nil, err
+	}
+
+	update.ID = int64(msg.(map[string]interface{})["message_id"].(float64))
+	return update, nil
+}
+
+func (a *APITelegramBot) sendLocation(update *Update) (*Update, error) {
+	l := update.Message.Location
+	if l == nil {
+		return nil, errors.New("Send location: Missing location")
+	}
+
+	m := map[string]interface{}{
+		"latitude":  l.Latitude,
+		"longitude": l.Longitude,
+	}
+	end := "sendLocation"
+	if l.Title != "" {
+		end = "sendVenue"
+		m["title"] = l.Title
+		m["address"] = l.Address
+		if l.Address == "" {
+			m["address"] = l.Title
 		}
+	}
+
+	u, err := a.send(end, update, m)
+	if err != nil {
+		return nil, fmt.Errorf("Send location: %v", err)
+	}
+	return u, nil
+}
 
-		if !m["ok"].(bool) {
-			return nil, fmt.Errorf("Send image: API %v: %v", "sendVoice", m["description"].(string))
+func (a *APITelegramBot) sendContact(update *Update) (*Update, error) {
+	c := update.Message.Contact
+	if c == nil {
+		return nil, errors.New("Send contact: Missing contact")
+	}
+	if c.Phone == "" {
+		return nil, errors.New("Send contact: Not supported without the phone number")
+	}
+
+	u, err := a.send("sendContact", update, map[string]interface{}{
+		"phone_number": c.Phone,
+		"first_name":   c.Name,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Send contact: %v", err)
+	}
+	return u, nil
+}
+
+// Push pushes an update and returns it back if existing.
+func (a *APITelegramBot) Push(update *Update) (*Update, error) {
+	if update.Type == "Delete" {
+		_, err := a.API("deleteMessage", map[string]interface{}{
+			"chat_id":    update.Chat.ID,
+			"message_id": update.ID,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Delete message: %v", err)
 		}
 
-		update.ID = int64(m["result"].(map[string]interface{})["message_id"].(float64))
+		return nil, nil
+	}
+	if update.Type == "Edit" {
+		return a.edit(update)
+	}
 
-		return update, nil
+	if len(update.Message.Segments) != 0 {
+		return a.pushSegments(update)
 	}
 
-	msg, err := a.API("sendMessage", map[string]interface{}{
+	switch update.Message.Type {
+	case "Image":
+		if telegramIsGIF(update.Message) {
+			return a.sendFile("sendAnimation", "animation", update)
+		}
+		return a.sendFile("sendPhoto", "photo", update)
+	case "Sticker":
+		return a.sendFile("sendSticker", "sticker", update)
+	case "Audio":
+		return a.sendFile("sendVoice", "voice", update)
+	case "Video":
+		return a.sendFile("sendVideo", "video", update)
+	case "Document":
+		return a.sendFile("sendDocument", "document", update)
+	case "Location":
+		return a.sendLocation(update)
+	case "Contact":
+		return a.sendContact(update)
+	}
+
+	m := map[string]interface{}{
 		"chat_id":    update.Chat.ID,
 		"text":       strings.TrimSpace(update.Message.Content),
 		"parse_mode": "HTML",
-	})
+	}
+	if update.Message.ReplyTo != nil {
+		m["reply_to_message_id"] = update.Message.ReplyTo.ID
+	}
+	if len(update.Message.Buttons) != 0 {
+		m["reply_markup"] = telegramReplyMarkup(update.Message.Buttons)
+	}
+
+	msg, err := a.API("sendMessage", m)
 	if err != nil {
 		return nil, fmt.Errorf("Send text message: %v", err)
 	}
@@ -447,6 +1312,61 @@ func (a *APITelegramBot) Push(update *Update) (*Update, error) {
 	return update, nil
 }
 
+func (a *APITelegramBot) fileURL(t, fileID string) (string, error) {
+	m, err := a.API("getFile", map[string]interface{}{
+		"file_id": fileID,
+	})
+	if err != nil {
+		return "", fmt.Errorf("Get file: %v", err)
+	}
+
+	p, ok := m.(map[string]interface{})["file_path"].(string)
+	if !ok {
+		return "", errors.New("Get file: File is not available")
+	}
+
+	return fmt.Sprintf(endPointFileTelegramBot, a.Token, p), nil
+}
+
+func (a *APITelegramBot) handleRequest(u *Update, approve bool, reason string) error {
+	if u.Type != "join_request" {
+		return errors.New("Handle request: Not a request")
+	}
+
+	end := "approveChatJoinRequest"
+	if !approve {
+		end = "declineChatJoinRequest"
+	}
+
+	_, err := a.API(end, map[string]interface{}{
+		"chat_id": u.Chat.ID,
+		"user_id": u.User.ID,
+	})
+	if err != nil {
+		return fmt.Errorf("Handle request: %v", err)
+	}
+	return nil
+}
+
+func (a *APITelegramBot) isChatAdmin(c *Chat, u *User) (bool, error) {
+	if c.Type == "private" {
+		return false, nil
+	}
+
+	m, err := a.Cache.Fetch(CacheMemberKey(a.Platform(), c.ID, u.ID), func() (interface{}, error) {
+		return a.API("getChatMember", map[string]interface{}{
+			"chat_id": c.ID,
+			"user_id": u.ID,
+		})
+	})
+	if err != nil {
+		return false, fmt.Errorf("Get member: %v", err)
+	}
+
+	status, _ := m.(map[string]interface{})["status"].(string)
+	return status == "creator" || status == "administrator", nil
+}
+
 // Platform returns a string showing the platform of the bot.
 func (a *APITelegramBot) Platform() string {
 	return "Telegram"
@@ -469,7 +1389,7 @@ func (a *APITelegramBot) ParseUserID(u *Update, s string) (int64, error) {
 	}
 
 	if strings.HasPrefix(s, "@") {
-		s = u.Bot.BotMaid.Redis.HGet("telegramUsers", s[1:]).Val()
+		s, _ = u.Bot.BotMaid.Store.HGet("telegramUsers", s[1:])
 
 		id, err := strconv.ParseInt(s, 10, 64)
 		if err != nil {
@@ -0,0 +1,225 @@
+package botmaid
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pelletier/go-toml"
+)
+
+// ErrAttachmentTooLarge is returned by Download when the attachment is larger
+// than Media.MaxSize.
+var ErrAttachmentTooLarge = errors.New("Download: Attachment is too large")
+
+// Attachment is a file attached to an incoming message, the type is one of
+// "Image", "Audio", "Sticker", "Video" and "Document".
+//
+// FileID is the platform ID of the file, URL is the URL of the file if the
+// platform provides it, and Size is 0 if it is unknown.
+type Attachment struct {
+	Type string
+
+	FileID string
+	URL    string
+	Name   string
+	MIME   string
+	Size   int64
+
+	Update *Update
+}
+
+type botmaidMediaConfig struct {
+	MaxSize  int64
+	CacheDir string
+}
+
+func (bm *BotMaid) readMediaConfig(conf *toml.Tree) error {
+	bm.Conf.Media.MaxSize = 20 * 1024 * 1024
+	if conf.Has("Media.MaxSize") {
+		i, ok := conf.Get("Media.MaxSize").(int64)
+		if !ok || i < 0 {
+			return fmt.Errorf("Invalid max size: %v", conf.Get("Media.MaxSize"))
+		}
+		bm.Conf.Media.MaxSize = i
+	}
+
+	if s, ok := conf.Get("Media.CacheDir").(string); ok && s != "" {
+		err := os.MkdirAll(s, 0755)
+		if err != nil {
+			return fmt.Errorf("Cache dir: %v", err)
+		}
+		bm.Conf.Media.CacheDir = s
+	}
+
+	return nil
+}
+
+// url returns the URL to download the attachment, or the local path of it.
+func (a *Attachment) url() (string, error) {
+	if a.URL != "" {
+		return a.URL, nil
+	}
+
+	r, ok := (*a.Update.Bot.API).(fileURLResolver)
+	if !ok || a.FileID == "" {
+		return "", errors.New("File is not available")
+	}
+	return r.fileURL(a.Type, a.FileID)
+}
+
+// cachePath returns the path of the attachment in the cache directory.
+func (a *Attachment) cachePath(dir string) string {
+	id := a.FileID
+	if id == "" {
+		id = a.URL
+	}
+
+	h := sha1.Sum([]byte(id))
+	return filepath.Join(dir, (*a.Update.Bot.API).Platform()+"_"+hex.EncodeToString(h[:]))
+}
+
+// Download downloads the attachment, it returns ErrAttachmentTooLarge if the
+// attachment is larger than Media.MaxSize, and the attachment is kept in
+// Media.CacheDir if it is set.
+func (a *Attachment) Download(ctx context.Context) (io.ReadCloser, error) {
+	conf := a.Update.Bot.BotMaid.Conf.Media
+	if conf.MaxSize > 0 && a.Size > conf.MaxSize {
+		return nil, ErrAttachmentTooLarge
+	}
+
+	path := ""
+	if conf.CacheDir != "" {
+		path = a.cachePath(conf.CacheDir)
+		f, err := os.Open(path)
+		if err == nil {
+			return f, nil
+		}
+	}
+
+	url, err := a.url()
+	if err != nil {
+		return nil, fmt.Errorf("Download: %v", err)
+	}
+	if !isURL(url) {
+		return a.open(url, conf.MaxSize)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Download: %v", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Download: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("Download: %v", resp.Status)
+	}
+	if conf.MaxSize > 0 && resp.ContentLength > conf.MaxSize {
+		resp.Body.Close()
+		return nil, ErrAttachmentTooLarge
+	}
+
+	body := io.ReadCloser(resp.Body)
+	if conf.MaxSize > 0 {
+		body = &limitedReadCloser{
+			r:   io.LimitReader(resp.Body, conf.MaxSize+1),
+			c:   resp.Body,
+			max: conf.MaxSize,
+		}
+	}
+	if path == "" {
+		return body, nil
+	}
+	defer body.Close()
+
+	tmp, err := ioutil.TempFile(conf.CacheDir, "download_")
+	if err != nil {
+		return nil, fmt.Errorf("Download: %v", err)
+	}
+	_, err = io.Copy(tmp, body)
+	tmp.Close()
+	if err != nil {
+		os.Remove(tmp.Name())
+		if err == ErrAttachmentTooLarge {
+			return nil, err
+		}
+		return nil, fmt.Errorf("Download: %v", err)
+	}
+
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		os.Remove(tmp.Name())
+		return nil, fmt.Errorf("Download: %v", err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Download: %v", err)
+	}
+	return f, nil
+}
+
+// open opens the attachment at the local path.
+func (a *Attachment) open(path string, max int64) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Download: %v", err)
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("Download: %v", err)
+	}
+	if max > 0 && fi.Size() > max {
+		f.Close()
+		return nil, ErrAttachmentTooLarge
+	}
+	return f, nil
+}
+
+// limitedReadCloser returns ErrAttachmentTooLarge once more than max bytes
+// are read.
+type limitedReadCloser struct {
+	r   io.Reader
+	c   io.Closer
+	n   int64
+	max int64
+}
+
+func (l *limitedReadCloser) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.n += int64(n)
+	if l.n > l.max {
+		return 0, ErrAttachmentTooLarge
+	}
+	return n, err
+}
+
+func (l *limitedReadCloser) Close() error {
+	return l.c.Close()
+}
+
+// attachmentType returns the type of an attachment of the MIME.
+func attachmentType(mime string) string {
+	switch {
+	case strings.HasPrefix(mime, "image/"):
+		return "Image"
+	case strings.HasPrefix(mime, "audio/"):
+		return "Audio"
+	case strings.HasPrefix(mime, "video/"):
+		return "Video"
+	}
+	return "Document"
+}
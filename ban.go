@@ -0,0 +1,260 @@
+package botmaid
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// Ban is a ban of a chat or a user of a bot.
+//
+// Target is "chat" or "user", Expire is zero if the ban never expires.
+type Ban struct {
+	Target string
+	ID     int64
+	Reason string
+	Expire time.Time
+}
+
+func banKey(b *Bot, target string) string {
+	return fmt.Sprintf("ban_%v_%v", target, b.ID)
+}
+
+func parseBan(target string, id int64, s string) *Ban {
+	args := strings.SplitN(s, "|", 2)
+	ban := &Ban{
+		Target: target,
+		ID:     id,
+	}
+
+	if i, err := strconv.ParseInt(args[0], 10, 64); err == nil && i != 0 {
+		ban.Expire = time.Unix(i, 0)
+	}
+	if len(args) == 2 {
+		ban.Reason = args[1]
+	}
+
+	return ban
+}
+
+// AddBan bans a chat or a user of the bot for a duration, the ban never
+// expires if the duration is 0.
+func (bm *BotMaid) AddBan(b *Bot, target string, id int64, d time.Duration, reason string) error {
+	expire := int64(0)
+	if d > 0 {
+		expire = time.Now().Add(d).Unix()
+	}
+
+	return bm.Store.HSet(banKey(b, target), strconv.FormatInt(id, 10), fmt.Sprintf("%v|%v", expire, reason))
+}
+
+// RemoveBan unbans a chat or a user of the bot.
+func (bm *BotMaid) RemoveBan(b *Bot, target string, id int64) error {
+	if target == "chat" {
+		bm.Store.SRem("ban_"+b.ID, id)
+	}
+
+	return bm.Store.HDel(banKey(b, target), strconv.FormatInt(id, 10))
+}
+
+// GetBan returns the ban of a chat or a user of the bot, or nil if it is not
+// banned.
+func (bm *BotMaid) GetBan(b *Bot, target string, id int64) *Ban {
+	s, err := bm.Store.HGet(banKey(b, target), strconv.FormatInt(id, 10))
+	if err != nil {
+		if target == "chat" {
+			if is, _ := bm.Store.SIsMember("ban_"+b.ID, id); is {
+				return &Ban{
+					Target: target,
+					ID:     id,
+				}
+			}
+		}
+		return nil
+	}
+
+	ban := parseBan(target, id, s)
+	if !ban.Expire.IsZero() && time.Now().After(ban.Expire) {
+		bm.Store.HDel(banKey(b, target), strconv.FormatInt(id, 10))
+		return nil
+	}
+
+	return ban
+}
+
+// Bans returns all bans of the bot which are not expired.
+func (bm *BotMaid) Bans(b *Bot) []*Ban {
+	bans := []*Ban{}
+
+	for _, target := range []string{"chat", "user"} {
+		m, _ := bm.Store.HGetAll(banKey(b, target))
+		for k := range m {
+			id, err := strconv.ParseInt(k, 10, 64)
+			if err != nil {
+				continue
+			}
+			if ban := bm.GetBan(b, target, id); ban != nil {
+				bans = append(bans, ban)
+			}
+		}
+	}
+
+	cs, _ := bm.Store.SMembers("ban_" + b.ID)
+	for _, v := range cs {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			continue
+		}
+		if _, err := bm.Store.HGet(banKey(b, "chat"), v); err == nil {
+			continue
+		}
+		bans = append(bans, &Ban{
+			Target: "chat",
+			ID:     id,
+		})
+	}
+
+	sort.Slice(bans, func(i, j int) bool {
+		if bans[i].Target != bans[j].Target {
+			return bans[i].Target < bans[j].Target
+		}
+		return bans[i].ID < bans[j].ID
+	})
+
+	return bans
+}
+
+// IsUserBanned checks if a user has been banned.
+func (bm *BotMaid) IsUserBanned(u *User) bool {
+	return bm.GetBan(u.Update.Bot, "user", u.ID) != nil
+}
+
+func (bm *BotMaid) banMiddleware(next Handler) Handler {
+	return func(u *Update) {
+		banned := (u.Chat != nil && bm.IsBanned(u.Chat)) || (u.User != nil && bm.IsUserBanned(u.User))
+		if banned && (u.User == nil || !bm.HasRole(u.User, "admin")) {
+			return
+		}
+
+		next(u)
+	}
+}
+
+// banTarget returns the target, the ID and the name of the target to ban, the
+// name is not empty if a reply has been sent for an invalid target.
+func (bm *BotMaid) banTarget(u *Update, f *pflag.FlagSet) (string, int64, string, bool) {
+	chat, _ := f.GetBool("chat")
+	if chat {
+		if len(f.Args()) < 2 {
+			return "chat", u.Chat.ID, strconv.FormatInt(u.Chat.ID, 10), true
+		}
+
+		id, err := strconv.ParseInt(f.Args()[1], 10, 64)
+		if err != nil {
+			bm.Reply(u, fmt.Sprintf(bm.Words["invalidChat"], bm.At(u.User), f.Args()[1]))
+			return "", 0, f.Args()[1], false
+		}
+		return "chat", id, f.Args()[1], true
+	}
+
+	if len(f.Args()) < 2 {
+		return "", 0, "", false
+	}
+
+	id, err := (*u.Bot.API).ParseUserID(u, f.Args()[1])
+	if err != nil {
+		bm.Reply(u, fmt.Sprintf(bm.Words["invalidUser"], bm.At(u.User), f.Args()[1]))
+		return "", 0, f.Args()[1], false
+	}
+	return "user", id, f.Args()[1], true
+}
+
+func (bm *BotMaid) banExpire(ban *Ban) string {
+	if ban.Expire.IsZero() {
+		return bm.Words["banForever"]
+	}
+	return fmt.Sprintf(bm.Words["banUntil"], ban.Expire.Format("2006-01-02 15:04:05"))
+}
+
+func (bm *BotMaid) BanCommandDo(u *Update, f *pflag.FlagSet) bool {
+	if !bm.CheckPermission(u, "admin", "ban") {
+		return true
+	}
+
+	target, id, name, ok := bm.banTarget(u, f)
+	if !ok {
+		return name != ""
+	}
+
+	d := time.Duration(0)
+	if s, _ := f.GetString("time"); s != "" {
+		var err error
+		d, err = parseDuration(s)
+		if err != nil || d <= 0 {
+			bm.Reply(u, fmt.Sprintf(bm.Words["invalidDuration"], bm.At(u.User), s))
+			return true
+		}
+	}
+
+	reason := ""
+	if len(f.Args()) > 2 {
+		reason = strings.Join(f.Args()[2:], " ")
+	}
+
+	bm.AddBan(u.Bot, target, id, d, reason)
+	bm.Reply(u, fmt.Sprintf(bm.Words["banned"], name, bm.banExpire(bm.GetBan(u.Bot, target, id))))
+	return true
+}
+
+func (bm *BotMaid) BanCommandHelpSetFlag(f *pflag.FlagSet) {
+	f.BoolP("chat", "c", false, bm.Words["banChatHelp"])
+	f.StringP("time", "t", "", bm.Words["banTimeHelp"])
+}
+
+func (bm *BotMaid) UnbanCommandDo(u *Update, f *pflag.FlagSet) bool {
+	if !bm.CheckPermission(u, "admin", "unban") {
+		return true
+	}
+
+	target, id, name, ok := bm.banTarget(u, f)
+	if !ok {
+		return name != ""
+	}
+
+	if bm.GetBan(u.Bot, target, id) == nil {
+		bm.Reply(u, fmt.Sprintf(bm.Words["notBanned"], name))
+		return true
+	}
+
+	bm.RemoveBan(u.Bot, target, id)
+	bm.Reply(u, fmt.Sprintf(bm.Words["unbanned"], name))
+	return true
+}
+
+func (bm *BotMaid) UnbanCommandHelpSetFlag(f *pflag.FlagSet) {
+	f.BoolP("chat", "c", false, bm.Words["unbanChatHelp"])
+}
+
+func (bm *BotMaid) BanlistCommandDo(u *Update, f *pflag.FlagSet) bool {
+	if !bm.CheckPermission(u, "admin", "banlist") {
+		return true
+	}
+
+	bans := bm.Bans(u.Bot)
+	if len(bans) == 0 {
+		bm.Reply(u, bm.Words["banListEmpty"])
+		return true
+	}
+
+	s := ""
+	for _, ban := range bans {
+		s += fmt.Sprintf(bm.Words["banListItem"], ban.Target, ban.ID, bm.banExpire(ban), ban.Reason)
+	}
+
+	bm.Reply(u, fmt.Sprintf(bm.Words["banList"], s))
+	return true
+}
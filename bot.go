@@ -2,7 +2,6 @@ package botmaid
 
 import (
 	"errors"
-	"fmt"
 	"strings"
 	"time"
 )
@@ -20,12 +19,13 @@ type Bot struct {
 
 // IsMaster checks if a user is master of the bot.
 func (bm *BotMaid) IsMaster(u *User) bool {
-	return bm.Redis.SIsMember("master_"+u.Update.Bot.ID, u.ID).Val()
+	is, _ := bm.Store.SIsMember("master_"+u.Update.Bot.ID, u.ID)
+	return is
 }
 
 // IsBanned checks if a user has been banned.
 func (bm *BotMaid) IsBanned(c *Chat) bool {
-	return bm.Redis.SIsMember("ban_"+c.Update.Bot.ID, c.ID).Val()
+	return bm.GetBan(c.Update.Bot, "chat", c.ID) != nil
 }
 
 // At returns a string to mention someone in a message.
@@ -39,6 +39,10 @@ func (bm *BotMaid) BeAt(u *Update) bool {
 		return false
 	}
 
+	if r := bm.ResolveReply(u); r != nil && r.User != nil && r.User.ID == u.Bot.Self.ID {
+		return true
+	}
+
 	for _, v := range (*u.Bot.API).ats(u.Bot.Self) {
 		if strings.Contains(u.Message.Content, v) {
 			return true
@@ -48,14 +52,32 @@ func (bm *BotMaid) BeAt(u *Update) bool {
 	return false
 }
 
+// ResolveReply returns the reference to the message replied by the message of
+// the update, or nil if it is not a reply. The sender and the content are got
+// from the platform if they are missing and the platform could get them.
+func (bm *BotMaid) ResolveReply(u *Update) *ReplyTo {
+	r := u.Message.ReplyTo
+	if r == nil || r.User != nil {
+		return r
+	}
+
+	if rr, ok := (*u.Bot.API).(replyResolver); ok {
+		rr.resolveReply(u)
+	}
+	return r
+}
+
 func (bm *BotMaid) antiReplyLoop(u *Update) {
+	bm.historyMu.Lock()
+	defer bm.historyMu.Unlock()
+
 	now := time.Now()
 	for len(bm.history[u.Chat.ID]) > 0 && now.Sub(bm.history[u.Chat.ID][0]) > time.Second {
 		bm.history[u.Chat.ID] = bm.history[u.Chat.ID][1:]
 	}
 	bm.history[u.Chat.ID] = append(bm.history[u.Chat.ID], now)
 	if len(bm.history[u.Chat.ID]) >= 5 {
-		bm.Redis.SAdd(fmt.Sprintf("ban_%v", u.Bot.ID), u.Chat.ID)
+		bm.penalize(u, "chat", u.Chat.ID)
 	}
 }
 
@@ -68,6 +90,26 @@ func (bm *BotMaid) Reply(u *Update, s string) (*Update, error) {
 			Content: s,
 		},
 		Chat: u.Chat,
+		Bot:  u.Bot,
+	})
+}
+
+// ReplyQuote replies a message back as a reply to the message of the update,
+// which is quoted on the platforms.
+func (bm *BotMaid) ReplyQuote(u *Update, s string) (*Update, error) {
+	bm.antiReplyLoop(u)
+
+	return (*u.Bot.API).Push(&Update{
+		Message: &Message{
+			Content: s,
+			ReplyTo: &ReplyTo{
+				ID:      u.Message.ID,
+				User:    u.User,
+				Content: replyExcerpt(u.Message.Content),
+			},
+		},
+		Chat: u.Chat,
+		Bot:  u.Bot,
 	})
 }
 
@@ -75,19 +117,114 @@ func (bm *BotMaid) Reply(u *Update, s string) (*Update, error) {
 func (bm *BotMaid) ReplyType(u *Update, s, t string) (*Update, error) {
 	bm.antiReplyLoop(u)
 
-	if Contains([]string{"", "Text", "Image", "Audio", "Sticker"}, t) {
+	if Contains([]string{"", "Text", "Image", "Audio", "Sticker", "Video", "Document"}, t) {
 		return (*u.Bot.API).Push(&Update{
 			Message: &Message{
 				Type:    t,
 				Content: s,
 			},
 			Chat: u.Chat,
+			Bot:  u.Bot,
 		})
 	}
 
 	return nil, errors.New("Invalid type of message")
 }
 
+// ReplyMedia replies a message of media built in the memory back, the type is
+// one of "Image", "Audio", "Sticker", "Video" and "Document".
+func (bm *BotMaid) ReplyMedia(u *Update, t string, m *Media) (*Update, error) {
+	if !Contains([]string{"Image", "Audio", "Sticker", "Video", "Document"}, t) {
+		return nil, errors.New("Invalid type of message")
+	}
+
+	bm.antiReplyLoop(u)
+
+	return (*u.Bot.API).Push(&Update{
+		Message: &Message{
+			Type:  t,
+			Media: m,
+		},
+		Chat: u.Chat,
+		Bot:  u.Bot,
+	})
+}
+
+// ReplyLocation replies a location back.
+func (bm *BotMaid) ReplyLocation(u *Update, l *Location) (*Update, error) {
+	bm.antiReplyLoop(u)
+
+	return (*u.Bot.API).Push(&Update{
+		Message: &Message{
+			Type:     "Location",
+			Location: l,
+		},
+		Chat: u.Chat,
+		Bot:  u.Bot,
+	})
+}
+
+// ReplyContact replies a contact back.
+func (bm *BotMaid) ReplyContact(u *Update, c *Contact) (*Update, error) {
+	bm.antiReplyLoop(u)
+
+	return (*u.Bot.API).Push(&Update{
+		Message: &Message{
+			Type:    "Contact",
+			Contact: c,
+		},
+		Chat: u.Chat,
+		Bot:  u.Bot,
+	})
+}
+
+// ReplySegments replies a message of segments back.
+func (bm *BotMaid) ReplySegments(u *Update, ss ...*Segment) (*Update, error) {
+	bm.antiReplyLoop(u)
+
+	return (*u.Bot.API).Push(&Update{
+		Message: &Message{
+			Segments: ss,
+		},
+		Chat: u.Chat,
+		Bot:  u.Bot,
+	})
+}
+
+// ReplyButtons replies a message with rows of buttons back, the buttons are
+// for the user of the update on the platforms without buttons.
+func (bm *BotMaid) ReplyButtons(u *Update, s string, bs ...[]*Button) (*Update, error) {
+	bm.antiReplyLoop(u)
+
+	return (*u.Bot.API).Push(&Update{
+		Message: &Message{
+			Content: s,
+			Buttons: bs,
+		},
+		Chat: u.Chat,
+		User: u.User,
+		Bot:  u.Bot,
+	})
+}
+
+// Edit edits the content of a message sent by the bot, and returns the
+// update of the message edited, whose ID may be changed on the platforms which
+// could not edit messages.
+func (bm *BotMaid) Edit(u *Update, s string) (*Update, error) {
+	if u.Message == nil {
+		return nil, errors.New("Edit: Not a message")
+	}
+
+	uu := *u
+	uu.Type = "Edit"
+	m := *u.Message
+	m.Content = s
+	m.Segments = nil
+	m.Update = &uu
+	uu.Message = &m
+	return (*u.Bot.API).Push(&uu)
+}
+
 func (bm *BotMaid) Delete(u *Update) (*Update, error) {
 	uu := *u
 	uu.Type = "Delete"
@@ -2,17 +2,18 @@
 package botmaid
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-redis/redis"
-	"github.com/google/shlex"
 	"github.com/pelletier/go-toml"
-	"github.com/spf13/pflag"
 )
 
 type botmaidRedisConfig struct {
@@ -22,9 +23,15 @@ type botmaidRedisConfig struct {
 }
 
 type botMaidConfig struct {
-	Redis         botmaidRedisConfig
-	Log           bool
-	CommandPrefix []string
+	Store           botmaidStoreConfig
+	Redis           botmaidRedisConfig
+	Log             bool
+	CommandPrefix   []string
+	ShutdownTimeout time.Duration
+	RateLimit       botmaidRateLimitConfig
+	Media           botmaidMediaConfig
+
+	ReminderLocation *time.Location
 }
 
 // BotMaid includes a slice of Bot and some methods to use them.
@@ -33,17 +40,47 @@ type BotMaid struct {
 
 	Conf *botMaidConfig
 
+	Store Store
+	Cache *Cache
+
+	// Redis is the client of the Store if it is a StoreRedis, or nil.
+	//
+	// Deprecated: Use Store instead.
 	Redis *redis.Client
 
-	Commands CommandSlice
-	Timers   []*Timer
-	Helps    []*Help
+	Commands      CommandSlice
+	EventHandlers EventHandlerSlice
+
+	CallbackHandlers CallbackHandlerSlice
+	InlineHandlers   InlineHandlerSlice
+	Timers           []*Timer
+	Helps            []*Help
+	Middlewares      []*Middleware
 
 	Words      map[string]string
 	SubEntries []string
+	Roles      map[string]int
+
+	Bridges []*Bridge
+
+	respTime  time.Time
+	history   map[int64][]time.Time
+	historyMu sync.Mutex
+
+	reminders     map[string]*scheduledReminder
+	remindersMu   sync.Mutex
+	remindersWake chan struct{}
 
-	respTime time.Time
-	history  map[int64][]time.Time
+	conversations   map[string]*Conversation
+	conversationsMu sync.Mutex
+
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+	ctx     context.Context
+	cancel  context.CancelFunc
+	done    chan struct{}
+	stopErr error
+	stopped bool
 }
 
 func (bm *BotMaid) readBotConfig(conf *toml.Tree, section string) error {
@@ -56,7 +93,9 @@ func (bm *BotMaid) readBotConfig(conf *toml.Tree, section string) error {
 	}
 
 	if botType == "QQ" {
-		q := &APICqhttp{}
+		q := &APICqhttp{
+			Cache: bm.Cache,
+		}
 
 		if s, ok := conf.Get(section + ".AccessToken").(string); ok {
 			q.AccessToken = s
@@ -70,6 +109,18 @@ func (bm *BotMaid) readBotConfig(conf *toml.Tree, section string) error {
 		if s, ok := conf.Get(section + ".WebsocketEndpoint").(string); ok {
 			q.WebsocketEndpoint = s
 		}
+		if s, ok := conf.Get(section + ".Mode").(string); ok {
+			if !Contains([]string{"websocket", "http", "reverse"}, s) {
+				return fmt.Errorf("Init botmaid: Unknown mode %v of %v", s, section)
+			}
+			q.Mode = s
+		}
+		if s, ok := conf.Get(section + ".Listen").(string); ok {
+			q.Listen = s
+		}
+		if s, ok := conf.Get(section + ".Path").(string); ok {
+			q.Path = s
+		}
 
 		for {
 			m, err := q.API("get_login_info", map[string]interface{}{})
@@ -96,11 +147,31 @@ func (bm *BotMaid) readBotConfig(conf *toml.Tree, section string) error {
 
 		*b.API = q
 	} else if botType == "Telegram" {
-		t := &APITelegramBot{}
+		t := &APITelegramBot{
+			Cache: bm.Cache,
+		}
 
 		if s, ok := conf.Get(section + ".Token").(string); ok {
 			t.Token = s
 		}
+		if s, ok := conf.Get(section + ".WebhookURL").(string); ok {
+			t.WebhookURL = s
+		}
+		if s, ok := conf.Get(section + ".WebhookListen").(string); ok {
+			t.WebhookListen = s
+		}
+		if s, ok := conf.Get(section + ".WebhookPath").(string); ok {
+			t.WebhookPath = s
+		}
+		if s, ok := conf.Get(section + ".WebhookSecret").(string); ok {
+			t.WebhookSecret = s
+		}
+		if s, ok := conf.Get(section + ".WebhookCert").(string); ok {
+			t.WebhookCert = s
+		}
+		if s, ok := conf.Get(section + ".WebhookKey").(string); ok {
+			t.WebhookKey = s
+		}
 
 		for {
 			m, err := t.API("getMe", map[string]interface{}{})
@@ -130,6 +201,63 @@ func (bm *BotMaid) readBotConfig(conf *toml.Tree, section string) error {
 			break
 		}
 		*b.API = t
+	} else if botType == "Discord" {
+		d := &APIDiscord{}
+
+		if s, ok := conf.Get(section + ".Token").(string); ok {
+			d.Token = s
+		}
+		if s, ok := conf.Get(section + ".GatewayEndpoint").(string); ok {
+			d.GatewayEndpoint = s
+		}
+		if s, ok := conf.Get(section + ".APIEndpoint").(string); ok {
+			d.APIEndpoint = s
+		}
+
+		for {
+			m, err := d.API("GET", "/users/@me", nil)
+			if err != nil {
+				if bm.Conf.Log {
+					log.Printf("Init botmaid: %v, retrying...\n", err)
+				}
+				time.Sleep(time.Second * 3)
+				continue
+			}
+
+			u := m.(map[string]interface{})
+			b.Self = &User{
+				ID:       parseSnowflake(u["id"]),
+				UserName: u["username"].(string),
+				NickName: u["username"].(string),
+				Update: &Update{
+					Bot: b,
+				},
+			}
+			if s, ok := u["global_name"].(string); ok && s != "" {
+				b.Self.NickName = s
+			}
+
+			break
+		}
+		*b.API = d
+	} else if botType == "Test" {
+		b.Self = &User{
+			ID:       1,
+			UserName: "botmaid",
+			NickName: "BotMaid",
+			Update: &Update{
+				Bot: b,
+			},
+		}
+		if i, ok := conf.Get(section + ".SelfID").(int64); ok {
+			b.Self.ID = i
+		}
+		if s, ok := conf.Get(section + ".SelfName").(string); ok {
+			b.Self.UserName = s
+			b.Self.NickName = s
+		}
+
+		*b.API = &APITest{}
 	} else {
 		return fmt.Errorf("Init botmaid: Unknown type of %v", section)
 	}
@@ -137,7 +265,7 @@ func (bm *BotMaid) readBotConfig(conf *toml.Tree, section string) error {
 	if ms, ok := conf.Get(section + ".Master").([]interface{}); ok {
 		for _, v := range ms {
 			if id, ok := v.(int64); ok {
-				bm.Redis.SAdd("master_"+b.ID, id)
+				bm.Store.SAdd("master_"+b.ID, id)
 			}
 		}
 	}
@@ -146,120 +274,133 @@ func (bm *BotMaid) readBotConfig(conf *toml.Tree, section string) error {
 	return nil
 }
 
-func (bm *BotMaid) startBot() {
+func (bm *BotMaid) startBot(ctx context.Context) {
+	h := bm.handler()
+
 	for _, b := range bm.Bots {
 		bot := b
+		bm.wg.Add(1)
 		go func(b *Bot) {
-			updates, errors := (*b.API).Pull(&PullConfig{
-				Limit:            100,
-				Timeout:          60,
-				RetryWaitingTime: time.Second * 3,
+			defer bm.wg.Done()
+
+			updates, errors := (*b.API).Pull(ctx, &PullConfig{
+				Limit:               100,
+				Timeout:             60,
+				RetryWaitingTime:    time.Second * 3,
+				MaxRetryWaitingTime: time.Minute,
 			})
 
-			if bm.Conf.Log {
-				go func() {
-					for err := range errors {
+			go func() {
+				for err := range errors {
+					if bm.Conf.Log {
 						log.Printf("Bot running: %v.\n", err)
 					}
-				}()
+				}
+			}()
+			if bm.Conf.Log {
 				log.Printf("[%v] %v (%v) has been loaded. Begin to get updates.\n", b.ID, b.Self.NickName, (*b.API).Platform())
 			}
 
 			for u := range updates {
+				if u.Message == nil && Contains([]string{"connected", "disconnected", "reconnecting"}, u.Type) && bm.Conf.Log {
+					log.Printf("[%v] %v (%v) is %v.\n", b.ID, b.Self.NickName, (*b.API).Platform(), u.Type)
+				}
+
 				up := u
+				bm.wg.Add(1)
 				go func(u *Update) {
-					if u.Message == nil || !u.Time.After(bm.respTime) {
-						return
-					}
+					defer bm.wg.Done()
 
 					u.Bot = b
-
-					u.Message.Flags = map[string]*pflag.FlagSet{}
-
-					if (*b.API).Platform() == "Telegram" {
-						if u.User != nil && u.User.UserName != "" {
-							bm.Redis.HSet("telegramUsers", fmt.Sprintf("%v", u.User.UserName), u.User.ID)
-						}
-
-						u.Message.Content = strings.ReplaceAll(u.Message.Content, "—", "--")
-					}
-
-					if bm.Conf.Log {
-						logText := u.Message.Content
-						if u.User != nil {
-							logText = u.User.NickName + ": " + logText
-						}
-						if u.Chat != nil && u.Chat.Title != "" {
-							logText = "[" + u.Chat.Title + "]" + logText
-						}
-						log.Println(logText)
-					}
-
-					args, err := shlex.Split(u.Message.Content)
-					u.Message.Args = args
-					u.Message.Command = bm.extractCommand(u)
-					if err != nil && u.Message.Command != "" {
-						bm.Reply(u, fmt.Sprintf(bm.Words["invalidParameters"], bm.At(u.User), u.Message.Content))
-						return
-					}
-
-					for _, c := range bm.Commands {
-						if c.Help != nil && c.Help.Menu != "" {
-							if c.Help.SetFlag == nil {
-								c.Help.SetFlag = func(flag *pflag.FlagSet) {}
-							}
-
-							u.Message.Flags[c.Help.Menu] = pflag.NewFlagSet(c.Help.Menu, pflag.ContinueOnError)
-							u.Message.Flags[c.Help.Menu].SortFlags = true
-							c.Help.SetFlag(u.Message.Flags[c.Help.Menu])
-
-							u.Message.Flags[c.Help.Menu].Parse(u.Message.Args)
-						}
-					}
-
-					for _, c := range bm.Commands {
-						if c.Help != nil && len(c.Help.Names) != 0 && !Contains(c.Help.Names, u.Message.Command) {
-							continue
-						}
-
-						if c.Help == nil || c.Help.Menu == "" {
-							if c.Do(u, nil) {
-								break
-							}
-							continue
-						}
-
-						if c.Do(u, u.Message.Flags[c.Help.Menu]) {
-							break
-						}
-					}
+					h(u)
 				}(up)
 			}
 		}(bot)
 	}
 }
 
-// New creates a BotMaid.
+// New creates a BotMaid from a config file.
 func New(configFile string) (*BotMaid, error) {
+	conf, err := toml.LoadFile(configFile)
+	if err != nil {
+		return nil, fmt.Errorf("Init botmaid: Read config: %v", err)
+	}
+
+	return newFromTree(conf)
+}
+
+// NewFromString creates a BotMaid from a config in TOML.
+func NewFromString(config string) (*BotMaid, error) {
+	conf, err := toml.Load(config)
+	if err != nil {
+		return nil, fmt.Errorf("Init botmaid: Read config: %v", err)
+	}
+
+	return newFromTree(conf)
+}
+
+func newFromTree(conf *toml.Tree) (*BotMaid, error) {
 	bm := &BotMaid{
 		Bots: map[string]*Bot{},
 		Conf: &botMaidConfig{
-			Log: true,
+			Log:             true,
+			ShutdownTimeout: time.Second * 10,
+
+			ReminderLocation: time.Local,
 		},
 
 		respTime: time.Now(),
 		history:  map[int64][]time.Time{},
-	}
 
-	conf, err := toml.LoadFile(configFile)
-	if err != nil {
-		return nil, fmt.Errorf("Init botmaid: Read config: %v", err)
+		reminders:     map[string]*scheduledReminder{},
+		remindersWake: make(chan struct{}, 1),
+		conversations: map[string]*Conversation{},
 	}
 
 	if f, ok := conf.Get("Log.Log").(bool); ok {
 		bm.Conf.Log = f
 	}
 
+	if s, ok := conf.Get("Shutdown.Timeout").(string); ok {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return nil, fmt.Errorf("Init botmaid: Shutdown timeout: %v", err)
+		}
+		bm.Conf.ShutdownTimeout = d
+	}
+
+	if s, ok := conf.Get("Reminder.Location").(string); ok {
+		loc, err := time.LoadLocation(s)
+		if err != nil {
+			return nil, fmt.Errorf("Init botmaid: Reminder location: %v", err)
+		}
+		bm.Conf.ReminderLocation = loc
+	}
+
+	bm.Cache = NewCache(time.Minute * 10)
+	if s, ok := conf.Get("Cache.TTL").(string); ok {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return nil, fmt.Errorf("Init botmaid: Cache TTL: %v", err)
+		}
+		bm.Cache.TTL = d
+	}
+
+	err := bm.readRateLimitConfig(conf)
+	if err != nil {
+		return nil, fmt.Errorf("Init botmaid: Rate limit: %v", err)
+	}
+
+	err = bm.readRoleConfig(conf)
+	if err != nil {
+		return nil, fmt.Errorf("Init botmaid: Role: %v", err)
+	}
+
+	err = bm.readMediaConfig(conf)
+	if err != nil {
+		return nil, fmt.Errorf("Init botmaid: Media: %v", err)
+	}
+
 	if ss, ok := conf.Get("Command.Prefix").([]interface{}); ok {
 		for _, v := range ss {
 			if s, ok := v.(string); ok {
@@ -270,7 +411,20 @@ func New(configFile string) (*BotMaid, error) {
 		bm.Conf.CommandPrefix = []string{"/"}
 	}
 
-	if conf.Has("Redis") {
+	if s, ok := conf.Get("Store.Type").(string); ok {
+		bm.Conf.Store.Type = s
+	} else if conf.Has("Redis") {
+		bm.Conf.Store.Type = "Redis"
+	} else {
+		bm.Conf.Store.Type = "Memory"
+	}
+	if s, ok := conf.Get("Store.Path").(string); ok {
+		bm.Conf.Store.Path = s
+	} else {
+		bm.Conf.Store.Path = "botmaid.json"
+	}
+
+	if bm.Conf.Store.Type == "Redis" {
 		bm.Conf.Redis.Address = "127.0.0.1"
 		if s, ok := conf.Get("Redis.Address").(string); ok {
 			bm.Conf.Redis.Address = s
@@ -281,14 +435,24 @@ func New(configFile string) (*BotMaid, error) {
 		if a, ok := conf.Get("Redis.Database").(int64); ok {
 			bm.Conf.Redis.Database = int(a)
 		}
-	}
 
-	if bm.Conf.Redis.Address != "" {
-		bm.Redis = redis.NewClient(&redis.Options{
+		s := NewStoreRedis(&redis.Options{
 			Addr:     bm.Conf.Redis.Address,
 			Password: bm.Conf.Redis.Password,
 			DB:       bm.Conf.Redis.Database,
 		})
+		bm.Store = s
+		bm.Redis = s.Client
+	} else if bm.Conf.Store.Type == "Memory" {
+		bm.Store = NewStoreMemory()
+	} else if bm.Conf.Store.Type == "File" {
+		s, err := NewStoreFile(bm.Conf.Store.Path)
+		if err != nil {
+			return nil, fmt.Errorf("Init botmaid: %v", err)
+		}
+		bm.Store = s
+	} else {
+		return nil, fmt.Errorf("Init botmaid: Unknown type of store %v", bm.Conf.Store.Type)
 	}
 
 	for _, v := range conf.Keys() {
@@ -308,6 +472,15 @@ func New(configFile string) (*BotMaid, error) {
 		}
 	}
 
+	for _, v := range conf.Keys() {
+		if strings.HasPrefix(v, "Bridge_") {
+			err := bm.readBridgeConfig(conf, v)
+			if err != nil {
+				return nil, fmt.Errorf("Read config: %v", err)
+			}
+		}
+	}
+
 	bm.Words = map[string]string{
 		"selfIntro": fmt.Sprintf(`%%v is a bot.
 
@@ -341,22 +514,149 @@ Use "help [COMMAND] for more information about a command."`, bm.Conf.CommandPref
 		"subEntriesFormat":    "\"%v\"",
 		"subEntriesSeparator": ", ",
 		"subEntriesAnd":       " and ",
+		"bridgeFormat":        "[%v] ",
+		"bridgeOn":            "The bridge \"%v\" has been turned on.",
+		"bridgeOff":           "The bridge \"%v\" has been turned off.",
+		"bridgeOnFormat":      "\"%v\" (on)",
+		"bridgeOffFormat":     "\"%v\" (off)",
+		"bridgeList":          "These bridges are configured: %v",
+		"unknownBridge":       "%v, the bridge \"%v\" is unknown.",
+		"banned":              "%v has been banned %v.",
+		"banUntil":            "until %v",
+		"banForever":          "forever",
+		"unbanned":            "%v has been unbanned.",
+		"notBanned":           "%v is not banned.",
+		"banList":             "These chats and users are banned:%v",
+		"banListEmpty":        "No chat or user is banned.",
+		"banListItem":         "\n  %v %v %v %v",
+		"banChatHelp":         "ban the chat instead of a user, the current chat will be banned if no chat ID is given",
+		"banTimeHelp":         "the duration of the ban, e.g. 30m, 12h or 7d, the ban never expires if not given",
+		"unbanChatHelp":       "unban the chat instead of a user, the current chat will be unbanned if no chat ID is given",
+		"invalidChat":         "%v, the chat \"%v\" is invalid.",
+		"invalidDuration":     "%v, the duration \"%v\" is invalid.",
+		"roleGranted":         "%v has been granted the role \"%v\"%v.",
+		"roleRevoked":         "The role \"%v\" of %v has been revoked%v.",
+		"roleChatScope":       " in this chat",
+		"roleList":            "%v has these roles: %v",
+		"roleListEmpty":       "%v has no role.",
+		"roleChatHelp":        "grant or revoke the role only in this chat",
+		"unknownRole":         "%v, the role \"%v\" is unknown.",
+		"reminderFormat":      "%v, %v",
+		"reminderSet":         "%v, I will remind you at %v (#%v).",
+		"scheduleSet":         "%v, the schedule #%v has been set, the next message will be sent at %v.",
+		"reminderList":        "%v, your reminders in this chat:%v",
+		"reminderListItem":    "\n  #%v %v %v",
+		"reminderListEmpty":   "%v, you have no reminder in this chat.",
+		"reminderCancelled":   "The reminder #%v has been cancelled.",
+		"unknownReminder":     "%v, the reminder \"%v\" is not found.",
+		"invalidSchedule":     "%v, the schedule \"%v\" is invalid.",
+		"invalidZone":         "%v, the time zone \"%v\" is invalid.",
+		"scheduleZoneHelp":    "the time zone of the schedule, like Asia/Shanghai",
+		"slowDown":            "%v, you are sending commands too fast, please slow down and retry after %v.",
 	}
 
+	bm.Middlewares = bm.defaultMiddlewares()
+
 	return bm, nil
 }
 
-// Start starts the BotMaid.
-func (bm *BotMaid) Start() error {
-	err := bm.Redis.Ping().Err()
+// Start starts the BotMaid and blocks until the context is done or Stop is
+// called, then it waits for the running Commands and Timers until the shutdown
+// timeout and closes the Store after they are done, so the BotMaid could not
+// be started again.
+func (bm *BotMaid) Start(ctx context.Context) error {
+	bm.mu.Lock()
+	stopped := bm.stopped
+	bm.mu.Unlock()
+	if stopped {
+		return errors.New("Init botmaid: Already stopped")
+	}
+
+	err := bm.Store.Ping()
 	if err != nil {
-		return fmt.Errorf("Init botmaid: Connect Redis: %v", err)
+		return fmt.Errorf("Init botmaid: Connect store: %v", err)
 	}
 
 	sort.Stable(CommandSlice(bm.Commands))
+	sort.Stable(bm.EventHandlers)
+	sort.Stable(bm.CallbackHandlers)
+	sort.Stable(bm.InlineHandlers)
+
+	err = bm.parseTimers()
+	if err != nil {
+		return err
+	}
+
+	bm.mu.Lock()
+	if bm.cancel != nil {
+		bm.mu.Unlock()
+		return errors.New("Init botmaid: Already started")
+	}
+	if bm.stopped {
+		bm.mu.Unlock()
+		return errors.New("Init botmaid: Already stopped")
+	}
+	bm.ctx, bm.cancel = context.WithCancel(ctx)
+	bm.done = make(chan struct{})
+	ctx = bm.ctx
+	bm.mu.Unlock()
+
+	bm.startBot(ctx)
+	bm.loadTimers(ctx)
+	bm.loadReminders(ctx)
+
+	<-ctx.Done()
+
+	wait := make(chan struct{})
+	go func() {
+		bm.wg.Wait()
+		close(wait)
+	}()
+
+	timer := time.NewTimer(bm.Conf.ShutdownTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-wait:
+		err = bm.Store.Close()
+		if err != nil {
+			err = fmt.Errorf("Stop botmaid: Close store: %v", err)
+		}
+	case <-timer.C:
+		err = errors.New("Stop botmaid: Timeout waiting for running commands")
+
+		// The Store is still used by the running commands.
+		go func() {
+			<-wait
+			bm.Store.Close()
+		}()
+	}
+
+	bm.mu.Lock()
+	bm.stopErr = err
+	bm.cancel = nil
+	bm.stopped = true
+	close(bm.done)
+	bm.mu.Unlock()
+
+	return err
+}
+
+// Stop stops the BotMaid started by Start and returns the error of the
+// shutdown.
+func (bm *BotMaid) Stop() error {
+	bm.mu.Lock()
+	if bm.cancel == nil {
+		bm.mu.Unlock()
+		return errors.New("Stop botmaid: Not started")
+	}
+	cancel, done := bm.cancel, bm.done
+	bm.mu.Unlock()
 
-	bm.startBot()
-	bm.loadTimers()
+	cancel()
+	<-done
 
-	select {}
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	return bm.stopErr
 }
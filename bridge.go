@@ -0,0 +1,293 @@
+package botmaid
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml"
+	"github.com/spf13/pflag"
+)
+
+// BridgeChat is a chat linked by a bridge.
+type BridgeChat struct {
+	BotID string
+	Type  string
+	ID    int64
+}
+
+// Bridge links some chats so that the messages in one of them will be relayed
+// to the others.
+type Bridge struct {
+	Name  string
+	Chats []*BridgeChat
+}
+
+func (bm *BotMaid) readBridgeConfig(conf *toml.Tree, section string) error {
+	b := &Bridge{
+		Name: strings.TrimPrefix(section, "Bridge_"),
+	}
+
+	cs, ok := conf.Get(section + ".Chats").([]interface{})
+	if !ok {
+		return fmt.Errorf("Init botmaid: Missing chats of %v", section)
+	}
+
+	for _, v := range cs {
+		s, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("Init botmaid: Invalid chat of %v", section)
+		}
+
+		args := strings.Split(s, "|")
+		if len(args) != 3 {
+			return fmt.Errorf("Init botmaid: Invalid chat %v of %v", s, section)
+		}
+		if _, ok := bm.Bots[args[0]]; !ok {
+			return fmt.Errorf("Init botmaid: Unknown bot %v of %v", args[0], section)
+		}
+		id, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			return fmt.Errorf("Init botmaid: Invalid chat %v of %v: %v", s, section, err)
+		}
+
+		b.Chats = append(b.Chats, &BridgeChat{
+			BotID: args[0],
+			Type:  args[1],
+			ID:    id,
+		})
+	}
+
+	if len(b.Chats) < 2 {
+		return fmt.Errorf("Init botmaid: A bridge should link at least 2 chats in %v", section)
+	}
+
+	bm.Bridges = append(bm.Bridges, b)
+	return nil
+}
+
+// IsBridgeOn checks if the bridge is relaying messages.
+func (bm *BotMaid) IsBridgeOn(name string) bool {
+	is, _ := bm.Store.SIsMember("bridge_off", name)
+	return !is
+}
+
+func (bm *BotMaid) bridgedKey(botID string, chatID, messageID int64) string {
+	return fmt.Sprintf("bridged_%v_%v_%v", botID, chatID, messageID)
+}
+
+// bridgeMessages returns the messages relaying the message of the update to
+// the bot. The media of other platforms are sent as the media downloaded by
+// download after the text, so that their URLs are not leaked.
+func (bm *BotMaid) bridgeMessages(u *Update, to *Bot, download func(*Segment) *Media) []*Message {
+	ss := []*Segment{
+		TextSegment(fmt.Sprintf(bm.Words["bridgeFormat"], u.User.NickName)),
+	}
+	ms := []*Message{}
+
+	from := u.Message.Segments
+	if len(from) == 0 {
+		from = []*Segment{TextSegment(u.Message.Content)}
+	}
+
+	for _, s := range from {
+		switch s.Type {
+		case "Text", "Link":
+			ss = append(ss, s)
+		case "Mention":
+			ss = append(ss, TextSegment("@"+mentionName(s.User)))
+		case "Emoji":
+			if to == u.Bot {
+				ss = append(ss, s)
+			} else if s.Text != "" {
+				ss = append(ss, TextSegment(s.Text))
+			}
+		case "Image", "Sticker", "Audio":
+			if to == u.Bot {
+				ss = append(ss, s)
+				continue
+			}
+			if isURL(s.File) {
+				ss = append(ss, &Segment{
+					Type: s.Type,
+					File: s.File,
+				})
+				continue
+			}
+
+			m := download(s)
+			if m == nil {
+				continue
+			}
+			ms = append(ms, &Message{
+				Type:  s.Type,
+				Media: m,
+			})
+		}
+	}
+
+	return append([]*Message{{Segments: ss}}, ms...)
+}
+
+// bridgeMedia downloads the file of the segment through the bot of the
+// update, it returns nil if the file could not be downloaded.
+func (bm *BotMaid) bridgeMedia(ctx context.Context, u *Update, s *Segment) *Media {
+	a := &Attachment{
+		Type:   s.Type,
+		FileID: s.File,
+		Update: u,
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	r, err := a.Download(ctx)
+	if err != nil {
+		if bm.Conf.Log {
+			log.Printf("[%v] Relay %v: %v\n", u.Bot.ID, strings.ToLower(s.Type), err)
+		}
+		return nil
+	}
+	defer r.Close()
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		if bm.Conf.Log {
+			log.Printf("[%v] Relay %v: %v\n", u.Bot.ID, strings.ToLower(s.Type), err)
+		}
+		return nil
+	}
+	return BytesMedia(strings.ToLower(s.Type), "", data)
+}
+
+func (bm *BotMaid) relay(ctx context.Context, u *Update) {
+	if u.Message == nil || u.Chat == nil || u.User == nil || u.User.ID == u.Bot.Self.ID || u.Type == "message_edited" {
+		return
+	}
+
+	if _, err := bm.Store.Get(bm.bridgedKey(u.Bot.ID, u.Chat.ID, u.Message.ID)); err == nil {
+		return
+	}
+
+	media := map[*Segment]*Media{}
+	download := func(s *Segment) *Media {
+		if m, ok := media[s]; ok {
+			return m
+		}
+		media[s] = bm.bridgeMedia(ctx, u, s)
+		return media[s]
+	}
+
+	for _, b := range bm.Bridges {
+		linked := false
+		for _, c := range b.Chats {
+			if c.BotID == u.Bot.ID && c.ID == u.Chat.ID {
+				linked = true
+				break
+			}
+		}
+		if !linked || !bm.IsBridgeOn(b.Name) {
+			continue
+		}
+
+		for _, c := range b.Chats {
+			if c.BotID == u.Bot.ID && c.ID == u.Chat.ID {
+				continue
+			}
+
+			to := bm.Bots[c.BotID]
+			for _, m := range bm.bridgeMessages(u, to, download) {
+				pushed, err := (*to.API).Push(&Update{
+					Message: m,
+					Chat: &Chat{
+						ID:   c.ID,
+						Type: c.Type,
+					},
+				})
+				if err != nil || pushed == nil {
+					continue
+				}
+
+				bm.Store.Set(bm.bridgedKey(c.BotID, c.ID, pushed.ID), 1, time.Hour)
+			}
+		}
+	}
+}
+
+// bridgeMiddleware relays the messages in a new goroutine, so that the
+// downloads and the uploads of the media do not hold the update up.
+func (bm *BotMaid) bridgeMiddleware(next Handler) Handler {
+	return func(u *Update) {
+		if len(bm.Bridges) != 0 && u.Message != nil {
+			bm.mu.Lock()
+			ctx := bm.ctx
+			bm.mu.Unlock()
+			if ctx == nil {
+				ctx = context.Background()
+			}
+
+			m := *u.Message
+			ru := *u
+			ru.Message = &m
+
+			bm.wg.Add(1)
+			go func() {
+				defer bm.wg.Done()
+
+				bm.relay(ctx, &ru)
+			}()
+		}
+
+		next(u)
+	}
+}
+
+func (bm *BotMaid) BridgeCommandDo(u *Update, f *pflag.FlagSet) bool {
+	if !bm.CheckPermission(u, "admin", "bridge") {
+		return true
+	}
+
+	if len(f.Args()) == 1 {
+		ls := []string{}
+		for _, b := range bm.Bridges {
+			if bm.IsBridgeOn(b.Name) {
+				ls = append(ls, fmt.Sprintf(bm.Words["bridgeOnFormat"], b.Name))
+			} else {
+				ls = append(ls, fmt.Sprintf(bm.Words["bridgeOffFormat"], b.Name))
+			}
+		}
+
+		bm.Reply(u, fmt.Sprintf(bm.Words["bridgeList"], ListToString(ls, "%v", bm.Words["subEntriesSeparator"], bm.Words["subEntriesAnd"])))
+		return true
+	}
+
+	if len(f.Args()) != 3 || (f.Args()[2] != "on" && f.Args()[2] != "off") {
+		return false
+	}
+
+	found := false
+	for _, b := range bm.Bridges {
+		if b.Name == f.Args()[1] {
+			found = true
+			break
+		}
+	}
+	if !found {
+		bm.Reply(u, fmt.Sprintf(bm.Words["unknownBridge"], bm.At(u.User), f.Args()[1]))
+		return true
+	}
+
+	if f.Args()[2] == "on" {
+		bm.Store.SRem("bridge_off", f.Args()[1])
+		bm.Reply(u, fmt.Sprintf(bm.Words["bridgeOn"], f.Args()[1]))
+		return true
+	}
+
+	bm.Store.SAdd("bridge_off", f.Args()[1])
+	bm.Reply(u, fmt.Sprintf(bm.Words["bridgeOff"], f.Args()[1]))
+	return true
+}
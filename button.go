@@ -0,0 +1,278 @@
+package botmaid
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Button is a button attached to a message, it opens URL if URL is not empty,
+// or sends a callback query with Data when it is pressed.
+type Button struct {
+	Text string
+	Data string
+	URL  string
+}
+
+// DataButton returns a button sending a callback query with the data.
+func DataButton(text, data string) *Button {
+	return &Button{
+		Text: text,
+		Data: data,
+	}
+}
+
+// URLButton returns a button opening the URL.
+func URLButton(text, url string) *Button {
+	return &Button{
+		Text: text,
+		URL:  url,
+	}
+}
+
+// Callback is a struct for a callback query of an update with the type
+// "callback_query", which is sent when a button is pressed.
+//
+// MessageID is the ID of the message with the button.
+type Callback struct {
+	ID        string
+	Data      string
+	MessageID int64
+}
+
+// CallbackHandler is a func with priority value handling the callback
+// queries whose data starts with Prefix.
+type CallbackHandler struct {
+	Do func(*Update) bool
+
+	Prefix   string
+	Priority int
+}
+
+// CallbackHandlerSlice is a slice of CallbackHandler that could be sort.
+type CallbackHandlerSlice []*CallbackHandler
+
+// Len is the length of a CallbackHandlerSlice.
+func (hs CallbackHandlerSlice) Len() int {
+	return len(hs)
+}
+
+// Swap swaps CallbackHandlerSlice[i] and CallbackHandlerSlice[j].
+func (hs CallbackHandlerSlice) Swap(i, j int) {
+	hs[i], hs[j] = hs[j], hs[i]
+}
+
+// Less returns true if CallbackHandlerSlice[i] is less then CallbackHandlerSlice[j].
+func (hs CallbackHandlerSlice) Less(i, j int) bool {
+	return hs[i].Priority > hs[j].Priority
+}
+
+// AddCallbackHandler adds a callback handler into the []CallbackHandler.
+func (bm *BotMaid) AddCallbackHandler(h *CallbackHandler) {
+	bm.CallbackHandlers = append(bm.CallbackHandlers, h)
+}
+
+func (bm *BotMaid) dispatchCallbacks(u *Update) {
+	for _, h := range bm.CallbackHandlers {
+		if !strings.HasPrefix(u.Callback.Data, h.Prefix) {
+			continue
+		}
+
+		if h.Do(u) {
+			break
+		}
+	}
+}
+
+type callbackAnswerer interface {
+	answerCallback(u *Update, text string, alert bool) error
+}
+
+// AnswerCallback answers the callback query of the update, the text is shown
+// as a notification, or an alert if alert is true.
+func (bm *BotMaid) AnswerCallback(u *Update, text string, alert bool) error {
+	if u.Callback == nil {
+		return errors.New("Answer callback: Not a callback query")
+	}
+
+	if a, ok := (*u.Bot.API).(callbackAnswerer); ok {
+		return a.answerCallback(u, text, alert)
+	}
+
+	if text == "" {
+		return nil
+	}
+	_, err := (*u.Bot.API).Push(&Update{
+		Message: &Message{
+			Content: text,
+		},
+		Chat: u.Chat,
+	})
+	return err
+}
+
+// buttonChoices renders the buttons as numbered choices in the text for the
+// platforms without buttons, and turns the messages of the numbers into
+// callback queries.
+//
+// The choices of a message with a user could only be chosen by the user, by
+// the number alone for the latest message to the user in the chat, or as a
+// reply to the message. The choices of a message without a user could be
+// chosen by anyone as a reply to the message. The choices of a message are
+// gone once one of them is taken.
+type buttonChoices struct {
+	mu      sync.Mutex
+	choices map[buttonChoiceKey]*buttonChoice
+	latest  map[buttonChoiceKey]int64
+}
+
+// buttonChoiceKey is the key of the choices of a message in a chat, or of the
+// latest message to a user in a chat.
+type buttonChoiceKey struct {
+	chatID int64
+	id     int64
+}
+
+type buttonChoice struct {
+	data   []string
+	userID int64
+	expire time.Time
+}
+
+// buttonChoicesTTL is the time the numbered choices of a message keep
+// available.
+const buttonChoicesTTL = time.Hour
+
+// render returns the text with the buttons appended, the buttons with data
+// are numbered from 1.
+func (bc *buttonChoices) render(text string, bs [][]*Button) (string, []string) {
+	data := []string{}
+	lines := []string{}
+
+	for _, row := range bs {
+		ls := []string{}
+		for _, b := range row {
+			if b.URL != "" {
+				ls = append(ls, fmt.Sprintf("%v: %v", b.Text, b.URL))
+				continue
+			}
+
+			data = append(data, b.Data)
+			ls = append(ls, fmt.Sprintf("%v. %v", len(data), b.Text))
+		}
+		lines = append(lines, strings.Join(ls, "  "))
+	}
+
+	if len(lines) == 0 {
+		return text, data
+	}
+	return text + "\n" + strings.Join(lines, "\n"), data
+}
+
+// remember keeps the data of the buttons of the message in the chat for the
+// user, and drops the expired ones.
+func (bc *buttonChoices) remember(chatID, userID, messageID int64, data []string) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	if bc.choices == nil {
+		bc.choices = map[buttonChoiceKey]*buttonChoice{}
+		bc.latest = map[buttonChoiceKey]int64{}
+	}
+
+	now := time.Now()
+	for k, c := range bc.choices {
+		if now.After(c.expire) {
+			delete(bc.choices, k)
+		}
+	}
+	for k, id := range bc.latest {
+		if _, ok := bc.choices[buttonChoiceKey{k.chatID, id}]; !ok {
+			delete(bc.latest, k)
+		}
+	}
+
+	if len(data) == 0 {
+		return
+	}
+	bc.choices[buttonChoiceKey{chatID, messageID}] = &buttonChoice{
+		data:   data,
+		userID: userID,
+		expire: now.Add(buttonChoicesTTL),
+	}
+	if userID != 0 {
+		bc.latest[buttonChoiceKey{chatID, userID}] = messageID
+	}
+}
+
+// callback returns the callback query of a message of the user choosing a
+// number, replyID is the ID of the message replied or 0. It returns nil if the
+// message is not choosing any choice available to the user. The choice is not
+// taken until take is called.
+func (bc *buttonChoices) callback(chatID, userID, replyID int64, content string) *Callback {
+	i, err := strconv.Atoi(strings.TrimSpace(content))
+	if err != nil || i < 1 {
+		return nil
+	}
+
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	messageID := replyID
+	if messageID == 0 {
+		id, ok := bc.latest[buttonChoiceKey{chatID, userID}]
+		if !ok {
+			return nil
+		}
+		messageID = id
+	}
+
+	k := buttonChoiceKey{chatID, messageID}
+	c, ok := bc.choices[k]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(c.expire) {
+		delete(bc.choices, k)
+		return nil
+	}
+	if c.userID != 0 && c.userID != userID {
+		return nil
+	}
+	if i > len(c.data) {
+		return nil
+	}
+
+	return &Callback{
+		Data:      c.data[i-1],
+		MessageID: messageID,
+	}
+}
+
+// take takes the choices of the message in the chat, it returns false if they
+// have been taken or expired.
+func (bc *buttonChoices) take(chatID, messageID int64) bool {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	k := buttonChoiceKey{chatID, messageID}
+	c, ok := bc.choices[k]
+	if !ok {
+		return false
+	}
+	delete(bc.choices, k)
+	if c.userID != 0 && bc.latest[buttonChoiceKey{chatID, c.userID}] == messageID {
+		delete(bc.latest, buttonChoiceKey{chatID, c.userID})
+	}
+	return time.Now().Before(c.expire)
+}
+
+// choiceTaker is implemented by the platforms rendering the buttons as
+// choices, the messages choosing one of them come with the Callback, which
+// is a callback query only if the choice is taken.
+type choiceTaker interface {
+	takeChoice(u *Update) bool
+}
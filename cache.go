@@ -0,0 +1,167 @@
+package botmaid
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cache is a TTL cache of the metadata of chats and users like titles,
+// nicknames and member cards shared by the adapters, so that they do not need
+// to ask the platforms for every update.
+//
+// A nil Cache caches nothing.
+type Cache struct {
+	TTL time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+}
+
+type cacheEntry struct {
+	value  interface{}
+	expire time.Time
+}
+
+// NewCache creates an empty Cache with the TTL.
+func NewCache(ttl time.Duration) *Cache {
+	return &Cache{
+		TTL:     ttl,
+		entries: map[string]*cacheEntry{},
+	}
+}
+
+// CacheChatKey returns the key of the metadata of a chat.
+func CacheChatKey(platform string, chatID int64) string {
+	return fmt.Sprintf("chat_%v_%v", platform, chatID)
+}
+
+// CacheUserKey returns the key of the metadata of a user.
+func CacheUserKey(platform string, userID int64) string {
+	return fmt.Sprintf("user_%v_%v", platform, userID)
+}
+
+// CacheMemberKey returns the key of the metadata of a user in a chat.
+func CacheMemberKey(platform string, chatID, userID int64) string {
+	return fmt.Sprintf("member_%v_%v_%v", platform, chatID, userID)
+}
+
+// CacheMessageKey returns the key of a message in a chat.
+func CacheMessageKey(platform string, chatID, messageID int64) string {
+	return fmt.Sprintf("message_%v_%v_%v", platform, chatID, messageID)
+}
+
+// Get gets the value of the key if it is not expired.
+func (c *Cache) Get(key string) (interface{}, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(e.expire) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return e.value, true
+}
+
+// Set sets the value of the key, which expires after the TTL.
+func (c *Cache) Set(key string, value interface{}) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[key] = &cacheEntry{
+		value:  value,
+		expire: time.Now().Add(c.TTL),
+	}
+}
+
+// Fetch gets the value of the key, or calls fetch and caches its result if
+// the key is missing or expired.
+func (c *Cache) Fetch(key string, fetch func() (interface{}, error)) (interface{}, error) {
+	if v, ok := c.Get(key); ok {
+		return v, nil
+	}
+
+	v, err := fetch()
+	if err != nil {
+		return nil, err
+	}
+	c.Set(key, v)
+	return v, nil
+}
+
+// Invalidate deletes the keys.
+func (c *Cache) Invalidate(keys ...string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, k := range keys {
+		delete(c.entries, k)
+	}
+}
+
+// InvalidateChat deletes the metadata of a chat and its members.
+func (c *Cache) InvalidateChat(platform string, chatID int64) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, CacheChatKey(platform, chatID))
+	prefix := fmt.Sprintf("member_%v_%v_", platform, chatID)
+	for k := range c.entries {
+		if strings.HasPrefix(k, prefix) {
+			delete(c.entries, k)
+		}
+	}
+}
+
+// InvalidateUser deletes the metadata of a user, including the ones in all
+// chats.
+func (c *Cache) InvalidateUser(platform string, userID int64) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, CacheUserKey(platform, userID))
+	prefix := fmt.Sprintf("member_%v_", platform)
+	suffix := fmt.Sprintf("_%v", userID)
+	for k := range c.entries {
+		if strings.HasPrefix(k, prefix) && strings.HasSuffix(k, suffix) {
+			delete(c.entries, k)
+		}
+	}
+}
+
+// Clear deletes everything.
+func (c *Cache) Clear() {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = map[string]*cacheEntry{}
+}
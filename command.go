@@ -7,11 +7,18 @@ import (
 )
 
 // Command is a func with priority value so that we can sort some Commands to make them in a specific order.
+//
+// Role is the role required to use the command, everyone could use it if Role
+// is empty. The command is run again when the message is edited if OnEdit is
+// true.
 type Command struct {
 	Do func(*Update, *pflag.FlagSet) bool
 
 	Priority int
 
+	Role   string
+	OnEdit bool
+
 	Help *Help
 }
 
@@ -0,0 +1,230 @@
+package botmaid
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrConversationTimeout is returned by Await when no message comes in
+	// time.
+	ErrConversationTimeout = errors.New("Conversation: Timeout")
+	// ErrConversationEnded is returned by Await when the conversation has been
+	// ended, replaced by a new one or the BotMaid is stopping.
+	ErrConversationEnded = errors.New("Conversation: Ended")
+	// ErrConversationNoUser is returned when starting a conversation with an
+	// update without a chat or a user.
+	ErrConversationNoUser = errors.New("Conversation: Missing chat or user")
+)
+
+// conversationIdleTimeout is the time after which a conversation not awaiting
+// is ended if it is never ended by its owner.
+const conversationIdleTimeout = time.Hour
+
+// Conversation is a session with a user in a chat, the messages sent by the
+// user in the chat are routed to the conversation instead of the Commands
+// while it is awaiting.
+type Conversation struct {
+	// Update is the latest update of the conversation.
+	Update *Update
+	// State keeps anything for the multi-step flow.
+	State map[string]interface{}
+
+	bm      *BotMaid
+	key     string
+	ch      chan *Update
+	done    chan struct{}
+	waiting bool
+	active  time.Time
+}
+
+func conversationKey(u *Update) (string, error) {
+	if u.Chat == nil || u.User == nil {
+		return "", ErrConversationNoUser
+	}
+	return fmt.Sprintf("%v_%v_%v", u.Bot.ID, u.Chat.ID, u.User.ID), nil
+}
+
+// sweepConversations ends the conversations idle for conversationIdleTimeout.
+func (bm *BotMaid) sweepConversations() {
+	now := time.Now()
+	for k, c := range bm.conversations {
+		if !c.waiting && now.Sub(c.active) > conversationIdleTimeout {
+			delete(bm.conversations, k)
+			close(c.done)
+		}
+	}
+}
+
+// StartConversation starts a conversation with the user of the update in the
+// chat of the update, the former one with the user in the chat is ended. It
+// returns ErrConversationNoUser if the update has no chat or no user.
+//
+// The conversation should be ended by End, or it is ended after being idle
+// for an hour.
+func (bm *BotMaid) StartConversation(u *Update) (*Conversation, error) {
+	key, err := conversationKey(u)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Conversation{
+		Update: u,
+		State:  map[string]interface{}{},
+		bm:     bm,
+		key:    key,
+		ch:     make(chan *Update, 1),
+		done:   make(chan struct{}),
+		active: time.Now(),
+	}
+
+	bm.conversationsMu.Lock()
+	bm.sweepConversations()
+	if old, ok := bm.conversations[c.key]; ok {
+		close(old.done)
+	}
+	bm.conversations[c.key] = c
+	bm.conversationsMu.Unlock()
+
+	return c, nil
+}
+
+// Conversation returns the running conversation with the user of the update
+// in the chat of the update, or nil if there is not.
+func (bm *BotMaid) Conversation(u *Update) *Conversation {
+	key, err := conversationKey(u)
+	if err != nil {
+		return nil
+	}
+
+	bm.conversationsMu.Lock()
+	defer bm.conversationsMu.Unlock()
+
+	bm.sweepConversations()
+	return bm.conversations[key]
+}
+
+// awaiting checks if the conversation with the user of the update in the chat
+// of the update is awaiting a message.
+func (bm *BotMaid) awaiting(u *Update) bool {
+	key, err := conversationKey(u)
+	if err != nil {
+		return false
+	}
+
+	bm.conversationsMu.Lock()
+	defer bm.conversationsMu.Unlock()
+
+	c, ok := bm.conversations[key]
+	return ok && c.waiting
+}
+
+// End ends the conversation.
+func (c *Conversation) End() {
+	c.bm.conversationsMu.Lock()
+	defer c.bm.conversationsMu.Unlock()
+
+	if c.bm.conversations[c.key] == c {
+		delete(c.bm.conversations, c.key)
+		close(c.done)
+	}
+}
+
+// Await waits for the next message of the conversation until the timeout.
+func (c *Conversation) Await(timeout time.Duration) (*Update, error) {
+	c.bm.mu.Lock()
+	ctx := c.bm.ctx
+	c.bm.mu.Unlock()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	c.bm.conversationsMu.Lock()
+	select {
+	case <-c.done:
+		c.bm.conversationsMu.Unlock()
+		return nil, ErrConversationEnded
+	default:
+	}
+	c.waiting = true
+	c.active = time.Now()
+	c.bm.conversationsMu.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	var err error
+	select {
+	case u := <-c.ch:
+		c.Update = u
+		return u, nil
+	case <-timer.C:
+		err = ErrConversationTimeout
+	case <-c.done:
+		err = ErrConversationEnded
+	case <-ctx.Done():
+		err = ErrConversationEnded
+	}
+
+	c.bm.conversationsMu.Lock()
+	defer c.bm.conversationsMu.Unlock()
+
+	c.waiting = false
+	c.active = time.Now()
+	// A message may have been routed just before giving up.
+	select {
+	case u := <-c.ch:
+		c.Update = u
+		return u, nil
+	default:
+	}
+	return nil, err
+}
+
+// Ask replies the message in the conversation, and waits for the answer until
+// the timeout.
+func (c *Conversation) Ask(s string, timeout time.Duration) (*Update, error) {
+	_, err := c.bm.Reply(c.Update, s)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.Await(timeout)
+}
+
+// Await starts a conversation with the user of the update in the chat of the
+// update, and waits for the next message until the timeout.
+func (bm *BotMaid) Await(u *Update, timeout time.Duration) (*Update, error) {
+	c, err := bm.StartConversation(u)
+	if err != nil {
+		return nil, err
+	}
+	defer c.End()
+
+	return c.Await(timeout)
+}
+
+func (bm *BotMaid) conversationMiddleware(next Handler) Handler {
+	return func(u *Update) {
+		key, err := conversationKey(u)
+		if err != nil || u.Type == "message_edited" {
+			next(u)
+			return
+		}
+
+		bm.conversationsMu.Lock()
+		c, ok := bm.conversations[key]
+		if ok && c.waiting {
+			c.waiting = false
+			c.active = time.Now()
+			c.ch <- u
+			bm.conversationsMu.Unlock()
+			return
+		}
+		bm.conversationsMu.Unlock()
+
+		next(u)
+	}
+}
@@ -0,0 +1,255 @@
+package botmaid
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CronSchedule is a parsed cron expression.
+type CronSchedule struct {
+	second, minute, hour, dom, month, dow uint64
+
+	// nth are the weekdays like "MON#1" which only match the nth one of the
+	// month.
+	nth []cronNth
+
+	domAny, dowAny bool
+}
+
+type cronNth struct {
+	weekday time.Weekday
+	n       int
+}
+
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 0 1 1 *",
+	"@annually": "0 0 0 1 1 *",
+	"@monthly":  "0 0 0 1 * *",
+	"@weekly":   "0 0 0 * * 0",
+	"@daily":    "0 0 0 * * *",
+	"@midnight": "0 0 0 * * *",
+	"@hourly":   "0 0 * * * *",
+}
+
+var cronMonths = map[string]int{
+	"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+	"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+}
+
+var cronWeekdays = map[string]int{
+	"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+}
+
+// ParseCron parses a cron expression with 5 fields (minute, hour, day of
+// month, month and day of week), 6 fields (with a leading second field) or a
+// descriptor like "@daily".
+//
+// A field could be "*", "?" (for days only), a value, a range "a-b", a step
+// "*/n", "a/n" or "a-b/n", or a list of them separated by commas. Months and
+// weekdays could be names like "JAN" and "MON", both 0 and 7 are Sunday, and
+// "MON#1" means the first Monday of the month. Like the traditional cron, a
+// day matches either of the day fields if both of them are restricted.
+func ParseCron(spec string) (*CronSchedule, error) {
+	spec = strings.TrimSpace(spec)
+	if d, ok := cronDescriptors[strings.ToLower(spec)]; ok {
+		spec = d
+	}
+
+	fs := strings.Fields(spec)
+	switch len(fs) {
+	case 5:
+		fs = append([]string{"0"}, fs...)
+	case 6:
+	default:
+		return nil, fmt.Errorf("Invalid cron %v: Expected 5 or 6 fields", spec)
+	}
+
+	s := &CronSchedule{}
+
+	var err error
+	if s.second, err = parseCronField(fs[0], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("Invalid second of cron %v: %v", spec, err)
+	}
+	if s.minute, err = parseCronField(fs[1], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("Invalid minute of cron %v: %v", spec, err)
+	}
+	if s.hour, err = parseCronField(fs[2], 0, 23, nil); err != nil {
+		return nil, fmt.Errorf("Invalid hour of cron %v: %v", spec, err)
+	}
+	if s.dom, err = parseCronField(fs[3], 1, 31, nil); err != nil {
+		return nil, fmt.Errorf("Invalid day of month of cron %v: %v", spec, err)
+	}
+	if s.month, err = parseCronField(fs[4], 1, 12, cronMonths); err != nil {
+		return nil, fmt.Errorf("Invalid month of cron %v: %v", spec, err)
+	}
+
+	dows := []string{}
+	for _, f := range strings.Split(fs[5], ",") {
+		if !strings.Contains(f, "#") {
+			dows = append(dows, f)
+			continue
+		}
+
+		args := strings.SplitN(f, "#", 2)
+		w, err := parseCronValue(args[0], 0, 7, cronWeekdays)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid day of week of cron %v: %v", spec, err)
+		}
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 1 || n > 5 {
+			return nil, fmt.Errorf("Invalid day of week of cron %v: Invalid nth %v", spec, args[1])
+		}
+		s.nth = append(s.nth, cronNth{
+			weekday: time.Weekday(w % 7),
+			n:       n,
+		})
+	}
+	if len(dows) != 0 {
+		s.dow, err = parseCronField(strings.Join(dows, ","), 0, 7, cronWeekdays)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid day of week of cron %v: %v", spec, err)
+		}
+		if s.dow&(1<<7) != 0 {
+			s.dow |= 1
+		}
+	}
+
+	s.domAny = fs[3] == "*" || fs[3] == "?"
+	s.dowAny = fs[5] == "*" || fs[5] == "?"
+
+	return s, nil
+}
+
+func parseCronValue(s string, min, max int, names map[string]int) (int, error) {
+	if v, ok := names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid value %v", s)
+	}
+	if v < min || v > max {
+		return 0, fmt.Errorf("Value %v out of range [%v, %v]", v, min, max)
+	}
+	return v, nil
+}
+
+func parseCronField(s string, min, max int, names map[string]int) (uint64, error) {
+	bits := uint64(0)
+
+	for _, f := range strings.Split(s, ",") {
+		step := 1
+		if i := strings.Index(f, "/"); i != -1 {
+			var err error
+			step, err = strconv.Atoi(f[i+1:])
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("Invalid step %v", f[i+1:])
+			}
+			f = f[:i]
+		}
+
+		from, to := min, max
+		switch {
+		case f == "*" || f == "?":
+		case strings.Contains(f, "-"):
+			args := strings.SplitN(f, "-", 2)
+			var err error
+			if from, err = parseCronValue(args[0], min, max, names); err != nil {
+				return 0, err
+			}
+			if to, err = parseCronValue(args[1], min, max, names); err != nil {
+				return 0, err
+			}
+			if from > to {
+				return 0, fmt.Errorf("Invalid range %v", f)
+			}
+		default:
+			var err error
+			if from, err = parseCronValue(f, min, max, names); err != nil {
+				return 0, err
+			}
+			if step == 1 {
+				to = from
+			}
+		}
+
+		for i := from; i <= to; i += step {
+			bits |= 1 << uint(i)
+		}
+	}
+
+	return bits, nil
+}
+
+func (s *CronSchedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	for _, n := range s.nth {
+		if n.weekday == t.Weekday() && (t.Day()-1)/7+1 == n.n {
+			dow = true
+		}
+	}
+
+	switch {
+	case s.domAny && s.dowAny:
+		return true
+	case s.domAny:
+		return dow
+	case s.dowAny:
+		return dom
+	}
+	return dom || dow
+}
+
+// Next returns the first time matching the schedule after t in the location
+// of t, or the zero time if there is no such time in 5 years.
+//
+// The wall clock times skipped by daylight saving time never match, and the
+// ones repeated only match once.
+func (s *CronSchedule) Next(t time.Time) time.Time {
+	loc := t.Location()
+	y, m, d := t.Date()
+
+	for i := 0; i < 366*5; i++ {
+		// Noon is never skipped by daylight saving time.
+		day := time.Date(y, m, d+i, 12, 0, 0, 0, loc)
+		if s.month&(1<<uint(day.Month())) == 0 || !s.dayMatches(day) {
+			continue
+		}
+
+		for h := 0; h < 24; h++ {
+			if s.hour&(1<<uint(h)) == 0 {
+				continue
+			}
+			if i == 0 && !time.Date(day.Year(), day.Month(), day.Day(), h, 59, 59, 0, loc).After(t) {
+				continue
+			}
+
+			for mi := 0; mi < 60; mi++ {
+				if s.minute&(1<<uint(mi)) == 0 {
+					continue
+				}
+
+				for sec := 0; sec < 60; sec++ {
+					if s.second&(1<<uint(sec)) == 0 {
+						continue
+					}
+
+					n := time.Date(day.Year(), day.Month(), day.Day(), h, mi, sec, 0, loc)
+					if n.Hour() != h || n.Minute() != mi {
+						continue
+					}
+					if n.After(t) {
+						return n
+					}
+				}
+			}
+		}
+	}
+
+	return time.Time{}
+}
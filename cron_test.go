@@ -0,0 +1,130 @@
+package botmaid
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronScheduleNext(t *testing.T) {
+	from := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		spec string
+		want time.Time
+	}{
+		{"0 9 * * *", time.Date(2021, 1, 1, 9, 0, 0, 0, time.UTC)},
+		{"30 0 9 * * *", time.Date(2021, 1, 1, 9, 0, 30, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2021, 1, 1, 0, 15, 0, 0, time.UTC)},
+		{"0 10-14/2 * * *", time.Date(2021, 1, 1, 10, 0, 0, 0, time.UTC)},
+		{"0 0 1 JAN,jul *", time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 2-3 *", time.Date(2021, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 12 * * MON-FRI", time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC)},
+		{"0 12 * * SAT,SUN", time.Date(2021, 1, 2, 12, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2021, 1, 3, 0, 0, 0, 0, time.UTC)},
+		{"0 8 * * MON#2", time.Date(2021, 1, 11, 8, 0, 0, 0, time.UTC)},
+		{"0 8 ? * fri#3", time.Date(2021, 1, 15, 8, 0, 0, 0, time.UTC)},
+		{"0 0 13 * FRI", time.Date(2021, 1, 8, 0, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2021, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2021, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 31 2 *", time.Time{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			s, err := ParseCron(tt.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := s.Next(from)
+			if !got.Equal(tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCronInvalid(t *testing.T) {
+	tests := []string{
+		"",
+		"* * * *",
+		"1 2 3 4 5 6 7",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * FOO *",
+		"* * * * 8",
+		"* * * * MON#0",
+		"* * * * MON#6",
+		"* * * * FOO#1",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+	}
+
+	for _, spec := range tests {
+		if _, err := ParseCron(spec); err == nil {
+			t.Errorf("ParseCron(%q): got no error", spec)
+		}
+	}
+}
+
+func TestCronScheduleNextDST(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("Time zone database is not available: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		spec string
+		from time.Time
+		want []time.Time
+	}{
+		{
+			// 2:30 does not exist on 2021-03-14, so it is skipped.
+			name: "spring forward",
+			spec: "30 2 * * *",
+			from: time.Date(2021, 3, 14, 0, 0, 0, 0, loc),
+			want: []time.Time{
+				time.Date(2021, 3, 15, 2, 30, 0, 0, loc),
+			},
+		},
+		{
+			// 1:30 happens twice on 2021-11-07, but it only matches once.
+			name: "fall back",
+			spec: "30 1 * * *",
+			from: time.Date(2021, 11, 7, 0, 0, 0, 0, loc),
+			want: []time.Time{
+				time.Date(2021, 11, 7, 5, 30, 0, 0, time.UTC),
+				time.Date(2021, 11, 8, 1, 30, 0, 0, loc),
+			},
+		},
+		{
+			name: "fall back between",
+			spec: "30 1 * * *",
+			from: time.Date(2021, 11, 7, 6, 0, 0, 0, time.UTC).In(loc),
+			want: []time.Time{
+				time.Date(2021, 11, 8, 1, 30, 0, 0, loc),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := ParseCron(tt.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			next := tt.from
+			for _, want := range tt.want {
+				next = s.Next(next)
+				if !next.Equal(want) {
+					t.Fatalf("got %v, want %v", next, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package botmaid
+
+import (
+	"errors"
+)
+
+// EventHandler is a func with priority value handling the updates which are
+// not messages, like the joins of members and the requests of friends.
+//
+// Types are the types of the updates handled, all the updates without
+// messages are handled if Types is empty.
+type EventHandler struct {
+	Do func(*Update) bool
+
+	Types    []string
+	Priority int
+}
+
+// EventHandlerSlice is a slice of EventHandler that could be sort.
+type EventHandlerSlice []*EventHandler
+
+// Len is the length of an EventHandlerSlice.
+func (hs EventHandlerSlice) Len() int {
+	return len(hs)
+}
+
+// Swap swaps EventHandlerSlice[i] and EventHandlerSlice[j].
+func (hs EventHandlerSlice) Swap(i, j int) {
+	hs[i], hs[j] = hs[j], hs[i]
+}
+
+// Less returns true if EventHandlerSlice[i] is less then EventHandlerSlice[j].
+func (hs EventHandlerSlice) Less(i, j int) bool {
+	return hs[i].Priority > hs[j].Priority
+}
+
+// AddEventHandler adds an event handler into the []EventHandler.
+func (bm *BotMaid) AddEventHandler(h *EventHandler) {
+	bm.EventHandlers = append(bm.EventHandlers, h)
+}
+
+func (bm *BotMaid) dispatchEvents(u *Update) {
+	if u.Chat != nil && bm.IsBanned(u.Chat) {
+		return
+	}
+
+	for _, h := range bm.EventHandlers {
+		if len(h.Types) != 0 && !Contains(h.Types, u.Type) {
+			continue
+		}
+
+		if h.Do(u) {
+			break
+		}
+	}
+}
+
+// Approve approves the request of the update.
+func (bm *BotMaid) Approve(u *Update) error {
+	r, ok := (*u.Bot.API).(requestHandler)
+	if !ok {
+		return errors.New("Approve: Not supported")
+	}
+
+	return r.handleRequest(u, true, "")
+}
+
+// Reject rejects the request of the update with the reason.
+func (bm *BotMaid) Reject(u *Update, reason string) error {
+	r, ok := (*u.Bot.API).(requestHandler)
+	if !ok {
+		return errors.New("Reject: Not supported")
+	}
+
+	return r.handleRequest(u, false, reason)
+}
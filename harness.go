@@ -0,0 +1,155 @@
+package botmaid
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/pelletier/go-toml"
+)
+
+// Harness is a BotMaid with a bot of the platform "Test" for testing
+// Commands without the real platforms, the updates are handled by Send and
+// Handle.
+//
+// Timeout is the max time Handle waits for the updates to be handled.
+type Harness struct {
+	// running is the first field so that it is 64-bit aligned for atomic.
+	running int64
+
+	*BotMaid
+
+	Bot *Bot
+	API *APITest
+
+	Timeout time.Duration
+}
+
+// NewHarness creates a Harness from a config in TOML, which always uses the
+// memory store, turns the log off by default, and adds a bot "Bot_Test" if
+// there is no bot of the type "Test".
+func NewHarness(config string) (*Harness, error) {
+	conf, err := toml.Load(config)
+	if err != nil {
+		return nil, fmt.Errorf("Init harness: Read config: %v", err)
+	}
+
+	if !conf.Has("Log.Log") {
+		conf.Set("Log.Log", false)
+	}
+	conf.Set("Store.Type", "Memory")
+
+	id := ""
+	for _, k := range conf.Keys() {
+		if strings.HasPrefix(k, "Bot_") && conf.Get(k+".Type") == "Test" {
+			id = k
+			break
+		}
+	}
+	if id == "" {
+		id = "Bot_Test"
+		conf.Set(id+".Type", "Test")
+	}
+
+	bm, err := newFromTree(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Harness{
+		BotMaid: bm,
+		Bot:     bm.Bots[id],
+		API:     (*bm.Bots[id].API).(*APITest),
+		Timeout: time.Second * 5,
+	}, nil
+}
+
+// Handle handles the update by the bot of the harness, and returns the
+// updates pushed while handling it.
+//
+// The update is handled in a new goroutine, and Handle returns when all the
+// updates handled by the harness are done or awaiting in conversations, so
+// that a conversation could be continued by the next update.
+func (h *Harness) Handle(u *Update) []*Update {
+	u.Bot = h.Bot
+	if u.Time.IsZero() {
+		u.Time = time.Now()
+	}
+	if u.Chat != nil {
+		u.Chat.Update = u
+	}
+	if u.User != nil {
+		u.User.Update = u
+	}
+	if u.Message != nil {
+		u.Message.Update = u
+	}
+
+	sort.Stable(CommandSlice(h.Commands))
+	sort.Stable(h.EventHandlers)
+	sort.Stable(h.CallbackHandlers)
+	sort.Stable(h.InlineHandlers)
+
+	n := len(h.API.Pushes())
+
+	atomic.AddInt64(&h.running, 1)
+	go func() {
+		defer atomic.AddInt64(&h.running, -1)
+
+		h.handler()(u)
+	}()
+
+	deadline := time.Now().Add(h.Timeout)
+	for !h.idle() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	return h.API.Pushes()[n:]
+}
+
+// idle checks if every running handler is awaiting in a conversation.
+func (h *Harness) idle() bool {
+	h.conversationsMu.Lock()
+	waiting := int64(0)
+	for _, c := range h.conversations {
+		if c.waiting {
+			waiting++
+		}
+	}
+	h.conversationsMu.Unlock()
+
+	return atomic.LoadInt64(&h.running) <= waiting
+}
+
+// Send handles a message sent by the user in the group chat, and returns the
+// updates pushed while handling it.
+func (h *Harness) Send(chatID, userID int64, s string) []*Update {
+	return h.Handle(&Update{
+		Type: "message_text",
+		Chat: &Chat{
+			ID:   chatID,
+			Type: "group",
+		},
+		User: &User{
+			ID:       userID,
+			NickName: fmt.Sprintf("User%v", userID),
+		},
+		Message: &Message{
+			Type:    "Text",
+			Content: s,
+		},
+	})
+}
+
+// Contents returns the contents of the messages of the updates.
+func (h *Harness) Contents(us []*Update) []string {
+	ss := []string{}
+	for _, u := range us {
+		if u.Message != nil {
+			ss = append(ss, u.Message.Content)
+		}
+	}
+	return ss
+}
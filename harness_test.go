@@ -0,0 +1,328 @@
+package botmaid
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+func newTestHarness(t *testing.T) *Harness {
+	t.Helper()
+
+	h, err := NewHarness(`
+[Bot_Test]
+Type = "Test"
+Master = [1]
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h.SubEntries = []string{"log"}
+	h.AddCommand(&Command{
+		Do: h.HelpCommandDo,
+		Help: &Help{
+			Menu:  "help",
+			Help:  "show the help",
+			Names: []string{"help"},
+		},
+	})
+	h.AddCommand(h.SubscribeCommand(&Help{
+		Menu:  "subscribe",
+		Help:  "subscribe an entry",
+		Names: []string{"subscribe"},
+	}))
+	h.AddCommand(h.VersetCommand(&Help{
+		Menu:  "verset",
+		Help:  "set the version",
+		Usage: "Usage: verset [VERSION] [--log LOG]",
+		Names: []string{"verset"},
+	}))
+	h.AddCommand(&Command{
+		Do: h.VersionCommandDo,
+		Help: &Help{
+			Menu:    "version",
+			Help:    "show the version",
+			Names:   []string{"version"},
+			SetFlag: h.VersionCommandHelpSetFlag,
+		},
+	})
+
+	return h
+}
+
+type harnessStep struct {
+	user int64
+	send string
+	want []string
+}
+
+func runSteps(t *testing.T, h *Harness, steps []harnessStep) {
+	t.Helper()
+
+	for _, s := range steps {
+		got := h.Contents(h.Send(100, s.user, s.send))
+		if !reflect.DeepEqual(got, s.want) {
+			t.Errorf("%v sends %q: got %q, want %q", s.user, s.send, got, s.want)
+		}
+	}
+}
+
+func TestHelpCommandDo(t *testing.T) {
+	h := newTestHarness(t)
+
+	menu := strings.Join([]string{
+		"  help  show the help",
+		"  subscribe  subscribe an entry",
+		"  verset  set the version",
+		"  version  show the version",
+	}, "\n")
+
+	tests := []struct {
+		name  string
+		send  string
+		check func(string) bool
+	}{
+		{
+			name: "menu",
+			send: "/help",
+			check: func(s string) bool {
+				return s == fmt.Sprintf(h.Words["selfIntro"], "BotMaid", "\n"+menu)
+			},
+		},
+		{
+			name: "command",
+			send: "/help verset",
+			check: func(s string) bool {
+				return strings.HasPrefix(s, "Usage: verset") && strings.Contains(s, "--log")
+			},
+		},
+		{
+			name: "unknown",
+			send: "/help nope",
+			check: func(s string) bool {
+				return s == fmt.Sprintf(h.Words["undefCommand"], "@2", "nope")
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := h.Contents(h.Send(100, 2, tt.send))
+			if len(got) != 1 || !tt.check(got[0]) {
+				t.Errorf("got %q", got)
+			}
+		})
+	}
+}
+
+func TestSubscribeCommandDo(t *testing.T) {
+	h := newTestHarness(t)
+
+	entries := fmt.Sprintf(h.Words["correctSubEntries"], `"log"`)
+	runSteps(t, h, []harnessStep{
+		{2, "/subscribe log", []string{fmt.Sprintf(h.Words["noPermission"], "@2", "subscribe")}},
+		{1, "/subscribe", []string{entries}},
+		{1, "/subscribe nope", []string{entries}},
+		{1, "/subscribe log", []string{fmt.Sprintf(h.Words["subscribed"], "log")}},
+		{1, "/subscribe log", []string{fmt.Sprintf(h.Words["unsubscribed"], "log")}},
+	})
+}
+
+func TestVersetCommandDo(t *testing.T) {
+	h := newTestHarness(t)
+
+	runSteps(t, h, []harnessStep{
+		{2, "/verset 1.0", []string{fmt.Sprintf(h.Words["noPermission"], "@2", "verset")}},
+		{1, "/verset 1.0", []string{fmt.Sprintf(h.Words["versionSet"], "1.0")}},
+		{1, "/verset --log fix", []string{fmt.Sprintf(h.Words["logAdded"], "fix")}},
+		{2, "/version", []string{fmt.Sprintf(h.Words["fmtVersion"], "1.0")}},
+		{2, "/version --log", []string{fmt.Sprintf(h.Words["fmtLog"], "1.0", "\n1. fix")}},
+	})
+}
+
+func TestOwnerCommandsRegisteredDirectly(t *testing.T) {
+	h, err := NewHarness(`
+[Bot_Test]
+Type = "Test"
+Master = [1]
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h.SubEntries = []string{"log"}
+	h.AddCommand(&Command{
+		Do: h.SubscribeCommandDo,
+		Help: &Help{
+			Menu:  "subscribe",
+			Names: []string{"subscribe"},
+		},
+	})
+	h.AddCommand(&Command{
+		Do: h.VersetCommandDo,
+		Help: &Help{
+			Menu:    "verset",
+			Names:   []string{"verset"},
+			SetFlag: h.VersetCommandHelpSetFlag,
+		},
+	})
+
+	runSteps(t, h, []harnessStep{
+		{2, "/subscribe log", []string{fmt.Sprintf(h.Words["noPermission"], "@2", "subscribe")}},
+		{2, "/verset 9.9", []string{fmt.Sprintf(h.Words["noPermission"], "@2", "verset")}},
+		{1, "/subscribe log", []string{fmt.Sprintf(h.Words["subscribed"], "log")}},
+		{1, "/verset 9.9", []string{fmt.Sprintf(h.Words["versionSet"], "9.9")}},
+	})
+}
+
+func TestRoleCommandWithoutUser(t *testing.T) {
+	h := newTestHarness(t)
+
+	got := h.Contents(h.Handle(&Update{
+		Type: "message_text",
+		Chat: &Chat{ID: 100, Type: "group"},
+		Message: &Message{
+			Type:    "Text",
+			Content: "/verset 1.0",
+		},
+	}))
+	if len(got) != 0 {
+		t.Errorf("got %q, want none", got)
+	}
+}
+
+func TestHarnessConversation(t *testing.T) {
+	h := newTestHarness(t)
+	h.AddCommand(&Command{
+		Do: func(u *Update, f *pflag.FlagSet) bool {
+			if u.Message.Content != "/name" {
+				return false
+			}
+
+			c, err := h.StartConversation(u)
+			if err != nil {
+				h.Reply(u, err.Error())
+				return true
+			}
+			defer c.End()
+
+			a, err := c.Ask("Name?", time.Second)
+			if err != nil {
+				h.Reply(u, err.Error())
+				return true
+			}
+			h.Reply(u, "Hi, "+a.Message.Content)
+			return true
+		},
+	})
+
+	runSteps(t, h, []harnessStep{
+		{2, "/name", []string{"Name?"}},
+		{2, "Alice", []string{"Hi, Alice"}},
+	})
+}
+
+func TestHandleSetsMessageUpdate(t *testing.T) {
+	h := newTestHarness(t)
+	h.AddCommand(&Command{
+		Do: func(u *Update, f *pflag.FlagSet) bool {
+			if u.Message.Update != u {
+				h.Reply(u, "Message.Update is not set")
+				return true
+			}
+			h.Reply(u.Message.Update, "ok")
+			return true
+		},
+	})
+
+	runSteps(t, h, []harnessStep{
+		{2, "hello", []string{"ok"}},
+	})
+}
+
+func TestStartConversationWithoutUser(t *testing.T) {
+	h := newTestHarness(t)
+
+	_, err := h.StartConversation(&Update{
+		Bot:  h.Bot,
+		Chat: &Chat{ID: 100, Type: "group"},
+	})
+	if err != ErrConversationNoUser {
+		t.Errorf("got error %v, want %v", err, ErrConversationNoUser)
+	}
+}
+
+func TestNewHarnessUsesMemoryStore(t *testing.T) {
+	h, err := NewHarness(`
+[Redis]
+Address = "127.0.0.1:1"
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := h.Store.(*StoreMemory); !ok {
+		t.Errorf("got store %T, want *StoreMemory", h.Store)
+	}
+}
+
+func TestDispatchWithoutMiddlewares(t *testing.T) {
+	h := newTestHarness(t)
+	h.Middlewares = nil
+	h.AddEventHandler(&EventHandler{
+		Do: func(u *Update) bool {
+			h.Reply(u, "Welcome")
+			return true
+		},
+		Types: []string{"join"},
+	})
+
+	got := h.Contents(h.Handle(&Update{
+		Type: "join",
+		Chat: &Chat{ID: 100, Type: "group"},
+		User: &User{ID: 2},
+	}))
+	if want := []string{"Welcome"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("join: got %q, want %q", got, want)
+	}
+
+	got = h.Contents(h.Handle(&Update{
+		Type: "join",
+		Time: h.respTime,
+		Chat: &Chat{ID: 100, Type: "group"},
+		User: &User{ID: 2},
+	}))
+	if len(got) != 0 {
+		t.Errorf("join before the response time: got %q, want none", got)
+	}
+}
+
+func TestStartAfterStop(t *testing.T) {
+	h := newTestHarness(t)
+
+	started := make(chan error, 1)
+	go func() {
+		started <- h.Start(context.Background())
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for h.Stop() != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("Start did not start")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := <-started; err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	if err := h.Start(context.Background()); err == nil {
+		t.Error("Start after Stop: got no error")
+	}
+}
@@ -0,0 +1,147 @@
+package botmaid
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"time"
+)
+
+// Inline is a struct for an inline query of an update with the type
+// "inline_query", which is sent when a user types "@bot query" in any chat.
+//
+// Offset is the offset of the results to return, which is the NextOffset of
+// the former answer when the user scrolls for more results.
+type Inline struct {
+	ID     string
+	Query  string
+	Offset string
+}
+
+// InlineResult is a result of an inline query, the type is one of "article",
+// "photo" and "sticker".
+//
+// An article sends Content when it is chosen, a photo is the one at URL or of
+// FileID, and a sticker is the one of FileID. Buttons are attached to the
+// message sent.
+type InlineResult struct {
+	Type string
+	ID   string
+
+	Title       string
+	Description string
+	Content     string
+	URL         string
+	ThumbURL    string
+	FileID      string
+
+	Buttons [][]*Button
+}
+
+// ArticleResult returns an article result sending the content.
+func ArticleResult(title, description, content string) *InlineResult {
+	return &InlineResult{
+		Type:        "article",
+		Title:       title,
+		Description: description,
+		Content:     content,
+	}
+}
+
+// PhotoResult returns a photo result of the photo at the URL.
+func PhotoResult(url string) *InlineResult {
+	return &InlineResult{
+		Type: "photo",
+		URL:  url,
+	}
+}
+
+// StickerResult returns a sticker result of the sticker of the file ID.
+func StickerResult(fileID string) *InlineResult {
+	return &InlineResult{
+		Type:   "sticker",
+		FileID: fileID,
+	}
+}
+
+// InlineAnswer is the answer of an inline query.
+//
+// CacheTime is the time the platform could cache the answer for the query,
+// the default one of the platform is used if it is zero. NextOffset is the
+// Offset of the next query for more results, or empty if there are no more
+// results. The answer is only cached for the user if Personal is true.
+type InlineAnswer struct {
+	Results    []*InlineResult
+	CacheTime  time.Duration
+	NextOffset string
+	Personal   bool
+}
+
+// InlineHandler is a func with priority value handling the inline queries
+// starting with Prefix, it returns nil if it does not answer the query.
+type InlineHandler struct {
+	Do func(*Update) *InlineAnswer
+
+	Prefix   string
+	Priority int
+}
+
+// InlineHandlerSlice is a slice of InlineHandler that could be sort.
+type InlineHandlerSlice []*InlineHandler
+
+// Len is the length of an InlineHandlerSlice.
+func (hs InlineHandlerSlice) Len() int {
+	return len(hs)
+}
+
+// Swap swaps InlineHandlerSlice[i] and InlineHandlerSlice[j].
+func (hs InlineHandlerSlice) Swap(i, j int) {
+	hs[i], hs[j] = hs[j], hs[i]
+}
+
+// Less returns true if InlineHandlerSlice[i] is less then InlineHandlerSlice[j].
+func (hs InlineHandlerSlice) Less(i, j int) bool {
+	return hs[i].Priority > hs[j].Priority
+}
+
+// AddInlineHandler adds an inline handler into the []InlineHandler.
+func (bm *BotMaid) AddInlineHandler(h *InlineHandler) {
+	bm.InlineHandlers = append(bm.InlineHandlers, h)
+}
+
+func (bm *BotMaid) dispatchInlines(u *Update) {
+	for _, h := range bm.InlineHandlers {
+		if !strings.HasPrefix(u.Inline.Query, h.Prefix) {
+			continue
+		}
+
+		a := h.Do(u)
+		if a == nil {
+			continue
+		}
+
+		err := bm.AnswerInline(u, a)
+		if err != nil && bm.Conf.Log {
+			log.Printf("[%v] %v\n", u.Bot.ID, err)
+		}
+		return
+	}
+}
+
+type inlineAnswerer interface {
+	answerInline(u *Update, a *InlineAnswer) error
+}
+
+// AnswerInline answers the inline query of the update.
+func (bm *BotMaid) AnswerInline(u *Update, a *InlineAnswer) error {
+	if u.Inline == nil {
+		return errors.New("Answer inline: Not an inline query")
+	}
+
+	i, ok := (*u.Bot.API).(inlineAnswerer)
+	if !ok {
+		return errors.New("Answer inline: Not supported")
+	}
+
+	return i.answerInline(u, a)
+}
@@ -7,8 +7,7 @@ import (
 )
 
 func (bm *BotMaid) MasterCommandDo(u *Update, f *pflag.FlagSet) bool {
-	if !bm.IsMaster(u.User) {
-		bm.Reply(u, fmt.Sprintf(bm.Words["noPermission"], bm.At(u.User), "master"))
+	if !bm.CheckPermission(u, "owner", "master") {
 		return true
 	}
 
@@ -22,15 +21,15 @@ func (bm *BotMaid) MasterCommandDo(u *Update, f *pflag.FlagSet) bool {
 		return true
 	}
 
-	is := bm.Redis.SIsMember("master_"+u.Bot.ID, id).Val()
+	is, _ := bm.Store.SIsMember("master_"+u.Bot.ID, id)
 
 	if is {
-		bm.Redis.SRem("master_"+u.Bot.ID, id)
+		bm.Store.SRem("master_"+u.Bot.ID, id)
 		bm.Reply(u, fmt.Sprintf(bm.Words["unregMaster"], bm.At(u.User), f.Args()[1]))
 		return true
 	}
 
-	bm.Redis.SAdd("master_"+u.Bot.ID, id)
+	bm.Store.SAdd("master_"+u.Bot.ID, id)
 	bm.Reply(u, fmt.Sprintf(bm.Words["regMaster"], bm.At(u.User), f.Args()[1]))
 	return true
 }
@@ -0,0 +1,110 @@
+package botmaid
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Media is the file of an outgoing message of media built in the memory, so
+// that it does not need to be saved as a file first.
+//
+// The file is read from Data, or from Reader if Data is nil, which could only
+// be sent once. MIME is guessed from Name if it is empty.
+type Media struct {
+	Name string
+	MIME string
+
+	Data   []byte
+	Reader io.Reader
+}
+
+// BytesMedia returns a media of the data.
+func BytesMedia(name, mime string, data []byte) *Media {
+	return &Media{
+		Name: name,
+		MIME: mime,
+		Data: data,
+	}
+}
+
+// ReaderMedia returns a media read from the reader.
+func ReaderMedia(name, mime string, r io.Reader) *Media {
+	return &Media{
+		Name:   name,
+		MIME:   mime,
+		Reader: r,
+	}
+}
+
+func (m *Media) reader() io.Reader {
+	if m.Data != nil {
+		return bytes.NewReader(m.Data)
+	}
+	if m.Reader == nil {
+		return bytes.NewReader(nil)
+	}
+	return m.Reader
+}
+
+func (m *Media) bytes() ([]byte, error) {
+	if m.Data != nil {
+		return m.Data, nil
+	}
+	return ioutil.ReadAll(m.reader())
+}
+
+func (m *Media) mime() string {
+	if m.MIME != "" {
+		return m.MIME
+	}
+	return mime.TypeByExtension(filepath.Ext(m.Name))
+}
+
+// key returns a key identifying the content of the media, or an empty string
+// if it could not be identified without reading the reader.
+func (m *Media) key() string {
+	if m.Data == nil {
+		return ""
+	}
+
+	h := sha1.Sum(m.Data)
+	return "data_" + hex.EncodeToString(h[:])
+}
+
+// isURL checks if the file is a URL.
+func isURL(file string) bool {
+	return strings.HasPrefix(file, "http://") || strings.HasPrefix(file, "https://")
+}
+
+// isFileID checks if the file is neither a URL nor an existing path, so that
+// it could only be the ID of a file on the platform.
+func isFileID(file string) bool {
+	if file == "" || isURL(file) {
+		return false
+	}
+
+	_, err := os.Stat(file)
+	return os.IsNotExist(err)
+}
+
+// fileKey returns a key identifying the content of the file at the path, which
+// changes if the file is modified.
+func fileKey(path string) string {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return ""
+	}
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return ""
+	}
+	return "file_" + abs + "_" + fi.ModTime().String() + "_" + storeString(fi.Size())
+}
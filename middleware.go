@@ -0,0 +1,261 @@
+package botmaid
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/google/shlex"
+	"github.com/spf13/pflag"
+)
+
+// Handler is a func handling an update.
+type Handler func(*Update)
+
+// Middleware is a named func wrapping a Handler so that we can do something
+// before and after the next Handler, or stop the update by not calling it.
+type Middleware struct {
+	Name string
+
+	Wrap func(next Handler) Handler
+}
+
+// AddMiddleware appends a middleware into the end of the chain, which means it
+// will run right before the Commands.
+func (bm *BotMaid) AddMiddleware(m *Middleware) {
+	bm.Middlewares = append(bm.Middlewares, m)
+}
+
+// InsertMiddleware inserts a middleware before the middleware with the name,
+// the middleware will be appended if the name is not found.
+func (bm *BotMaid) InsertMiddleware(before string, m *Middleware) {
+	for i, v := range bm.Middlewares {
+		if v.Name == before {
+			bm.Middlewares = append(bm.Middlewares[:i], append([]*Middleware{m}, bm.Middlewares[i:]...)...)
+			return
+		}
+	}
+
+	bm.AddMiddleware(m)
+}
+
+// RemoveMiddleware removes the middlewares with the name.
+func (bm *BotMaid) RemoveMiddleware(name string) {
+	ms := []*Middleware{}
+	for _, v := range bm.Middlewares {
+		if v.Name != name {
+			ms = append(ms, v)
+		}
+	}
+	bm.Middlewares = ms
+}
+
+func (bm *BotMaid) handler() Handler {
+	h := bm.dispatchCommands
+	for i := len(bm.Middlewares) - 1; i >= 0; i-- {
+		h = bm.Middlewares[i].Wrap(h)
+	}
+	return bm.dispatch(h)
+}
+
+// dispatch routes the callbacks, the inline queries and the events to their
+// handlers before the middlewares, so that they do not depend on any
+// middleware which could be removed, and only the messages go through the
+// chain.
+func (bm *BotMaid) dispatch(next Handler) Handler {
+	return func(u *Update) {
+		if u.Message != nil && u.Callback != nil {
+			// The message chooses a choice of the buttons, which becomes a
+			// callback query if it is not answering a conversation.
+			if t, ok := (*u.Bot.API).(choiceTaker); ok && !bm.awaiting(u) && t.takeChoice(u) {
+				u.Type = "callback_query"
+				u.Message = nil
+			} else {
+				u.Callback = nil
+			}
+		}
+		if u.Message != nil {
+			next(u)
+			return
+		}
+		if !u.Time.After(bm.respTime) {
+			return
+		}
+
+		switch {
+		case u.Callback != nil:
+			bm.dispatchCallbacks(u)
+		case u.Inline != nil:
+			bm.dispatchInlines(u)
+		default:
+			bm.dispatchEvents(u)
+		}
+	}
+}
+
+func (bm *BotMaid) dispatchCommands(u *Update) {
+	if u.Message == nil {
+		return
+	}
+
+	for _, c := range bm.Commands {
+		if u.Type == "message_edited" && !c.OnEdit {
+			continue
+		}
+		if c.Help != nil && len(c.Help.Names) != 0 && !Contains(c.Help.Names, u.Message.Command) {
+			continue
+		}
+
+		if c.Role != "" && (u.User == nil || !bm.HasRole(u.User, c.Role)) {
+			if c.Help != nil && len(c.Help.Names) != 0 {
+				bm.CheckPermission(u, c.Role, u.Message.Command)
+				break
+			}
+			continue
+		}
+
+		if c.Help == nil || c.Help.Menu == "" {
+			if c.Do(u, nil) {
+				break
+			}
+			continue
+		}
+
+		if c.Do(u, u.Message.Flags[c.Help.Menu]) {
+			break
+		}
+	}
+}
+
+func (bm *BotMaid) defaultMiddlewares() []*Middleware {
+	return []*Middleware{
+		{
+			Name: "filter",
+			Wrap: bm.filterMiddleware,
+		},
+		{
+			Name: "telegram",
+			Wrap: bm.telegramMiddleware,
+		},
+		{
+			Name: "ban",
+			Wrap: bm.banMiddleware,
+		},
+		{
+			Name: "log",
+			Wrap: bm.logMiddleware,
+		},
+		{
+			Name: "bridge",
+			Wrap: bm.bridgeMiddleware,
+		},
+		{
+			Name: "parse",
+			Wrap: bm.parseMiddleware,
+		},
+		{
+			Name: "penalty",
+			Wrap: bm.penaltyMiddleware,
+		},
+		{
+			Name: "conversation",
+			Wrap: bm.conversationMiddleware,
+		},
+		{
+			Name: "ratelimit",
+			Wrap: bm.rateLimitMiddleware,
+		},
+		{
+			Name: "flag",
+			Wrap: bm.flagMiddleware,
+		},
+	}
+}
+
+func (bm *BotMaid) filterMiddleware(next Handler) Handler {
+	return func(u *Update) {
+		if !u.Time.After(bm.respTime) {
+			return
+		}
+
+		next(u)
+	}
+}
+
+func (bm *BotMaid) telegramMiddleware(next Handler) Handler {
+	return func(u *Update) {
+		if (*u.Bot.API).Platform() == "Telegram" {
+			if u.User != nil && u.User.UserName != "" {
+				bm.Store.HSet("telegramUsers", fmt.Sprintf("%v", u.User.UserName), u.User.ID)
+			}
+
+			if u.Message != nil {
+				u.Message.Content = strings.ReplaceAll(u.Message.Content, "—", "--")
+			}
+		}
+
+		next(u)
+	}
+}
+
+func (bm *BotMaid) logMiddleware(next Handler) Handler {
+	return func(u *Update) {
+		if bm.Conf.Log && u.Message != nil {
+			logText := u.Message.Content
+			if u.User != nil {
+				logText = u.User.NickName + ": " + logText
+			}
+			if u.Chat != nil && u.Chat.Title != "" {
+				logText = "[" + u.Chat.Title + "]" + logText
+			}
+			log.Println(logText)
+		}
+
+		next(u)
+	}
+}
+
+func (bm *BotMaid) parseMiddleware(next Handler) Handler {
+	return func(u *Update) {
+		if u.Message == nil {
+			next(u)
+			return
+		}
+
+		args, err := shlex.Split(u.Message.Content)
+		u.Message.Args = args
+		u.Message.Command = bm.extractCommand(u)
+		if err != nil && u.Message.Command != "" {
+			bm.Reply(u, fmt.Sprintf(bm.Words["invalidParameters"], bm.At(u.User), u.Message.Content))
+			return
+		}
+
+		next(u)
+	}
+}
+
+func (bm *BotMaid) flagMiddleware(next Handler) Handler {
+	return func(u *Update) {
+		if u.Message == nil {
+			next(u)
+			return
+		}
+
+		u.Message.Flags = map[string]*pflag.FlagSet{}
+		for _, c := range bm.Commands {
+			if c.Help != nil && c.Help.Menu != "" {
+				if c.Help.SetFlag == nil {
+					c.Help.SetFlag = func(flag *pflag.FlagSet) {}
+				}
+
+				u.Message.Flags[c.Help.Menu] = pflag.NewFlagSet(c.Help.Menu, pflag.ContinueOnError)
+				u.Message.Flags[c.Help.Menu].SortFlags = true
+				c.Help.SetFlag(u.Message.Flags[c.Help.Menu])
+
+				u.Message.Flags[c.Help.Menu].Parse(u.Message.Args)
+			}
+		}
+
+		next(u)
+	}
+}
@@ -3,6 +3,9 @@ package botmaid
 import (
 	"fmt"
 	"reflect"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // Contains checks if the element is in the slice.
@@ -35,3 +38,17 @@ func ListToString(list []string, format string, separator string, and string) st
 	ret += and + fmt.Sprintf(format, list[len(list)-1])
 	return ret
 }
+
+// parseDuration parses a duration like time.ParseDuration, but also accepts
+// days like "7d".
+func parseDuration(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		i, err := strconv.ParseInt(strings.TrimSuffix(s, "d"), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("Invalid duration: %v", s)
+		}
+		return time.Duration(i) * time.Hour * 24, nil
+	}
+
+	return time.ParseDuration(s)
+}
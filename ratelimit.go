@@ -0,0 +1,212 @@
+package botmaid
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pelletier/go-toml"
+)
+
+// RateLimit is a token bucket which holds at most Burst tokens and gets a new
+// token every Interval.
+type RateLimit struct {
+	Interval time.Duration
+	Burst    int64
+}
+
+type botmaidRateLimitConfig struct {
+	User     *RateLimit
+	Chat     *RateLimit
+	Commands map[string]*RateLimit
+	Penalty  time.Duration
+}
+
+func readRateLimit(conf *toml.Tree, key string) (*RateLimit, error) {
+	if !conf.Has(key) {
+		return nil, nil
+	}
+
+	rl := &RateLimit{
+		Burst: 1,
+	}
+
+	s, ok := conf.Get(key + ".Interval").(string)
+	if !ok {
+		return nil, fmt.Errorf("Missing interval of %v", key)
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid interval of %v: %v", key, err)
+	}
+	if d <= 0 {
+		return nil, fmt.Errorf("Invalid interval of %v: %v", key, s)
+	}
+	rl.Interval = d
+
+	if conf.Has(key + ".Burst") {
+		i, ok := conf.Get(key + ".Burst").(int64)
+		if !ok || i <= 0 {
+			return nil, fmt.Errorf("Invalid burst of %v: %v", key, conf.Get(key+".Burst"))
+		}
+		rl.Burst = i
+	}
+
+	return rl, nil
+}
+
+func (bm *BotMaid) readRateLimitConfig(conf *toml.Tree) error {
+	var err error
+
+	bm.Conf.RateLimit.User, err = readRateLimit(conf, "RateLimit.User")
+	if err != nil {
+		return err
+	}
+	bm.Conf.RateLimit.Chat, err = readRateLimit(conf, "RateLimit.Chat")
+	if err != nil {
+		return err
+	}
+
+	bm.Conf.RateLimit.Commands = map[string]*RateLimit{}
+	if t, ok := conf.Get("RateLimit.Command").(*toml.Tree); ok {
+		for _, k := range t.Keys() {
+			bm.Conf.RateLimit.Commands[k], err = readRateLimit(conf, "RateLimit.Command."+k)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	bm.Conf.RateLimit.Penalty = time.Minute
+	if s, ok := conf.Get("RateLimit.Penalty").(string); ok {
+		bm.Conf.RateLimit.Penalty, err = time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("Invalid penalty: %v", err)
+		}
+		if bm.Conf.RateLimit.Penalty <= 0 {
+			return fmt.Errorf("Invalid penalty: %v", s)
+		}
+	}
+
+	return nil
+}
+
+func (bm *BotMaid) penaltyKey(u *Update, target string, id int64) string {
+	return fmt.Sprintf("penalty_%v_%v_%v", u.Bot.ID, target, id)
+}
+
+// IsPenalized checks if the user or the chat of the update is temporarily
+// ignored because of sending too fast.
+func (bm *BotMaid) IsPenalized(u *Update) bool {
+	if u.Chat != nil {
+		if _, err := bm.Store.Get(bm.penaltyKey(u, "chat", u.Chat.ID)); err == nil {
+			return true
+		}
+	}
+	if u.User != nil {
+		if _, err := bm.Store.Get(bm.penaltyKey(u, "user", u.User.ID)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (bm *BotMaid) penalize(u *Update, target string, id int64) {
+	if bm.Conf.RateLimit.Penalty <= 0 {
+		// A key set without an expiration would never expire.
+		return
+	}
+	bm.Store.Set(bm.penaltyKey(u, target, id), 1, bm.Conf.RateLimit.Penalty)
+}
+
+func (bm *BotMaid) takeToken(key string, rl *RateLimit) (bool, time.Duration) {
+	if rl == nil {
+		return true, 0
+	}
+
+	ok, wait, err := bm.Store.TakeToken("ratelimit_"+key, rl.Interval, rl.Burst)
+	if err != nil {
+		return true, 0
+	}
+	return ok, wait
+}
+
+func (bm *BotMaid) commandRateLimit(command string) (string, *RateLimit) {
+	if rl, ok := bm.Conf.RateLimit.Commands[command]; ok {
+		return command, rl
+	}
+
+	for _, c := range bm.Commands {
+		if c.Help == nil || !Contains(c.Help.Names, command) {
+			continue
+		}
+
+		for _, n := range c.Help.Names {
+			if rl, ok := bm.Conf.RateLimit.Commands[n]; ok {
+				return n, rl
+			}
+		}
+	}
+
+	return "", nil
+}
+
+// runsOnEdit checks if any command run again on edited messages matches the
+// command.
+func (bm *BotMaid) runsOnEdit(command string) bool {
+	for _, c := range bm.Commands {
+		if c.OnEdit && (c.Help == nil || len(c.Help.Names) == 0 || Contains(c.Help.Names, command)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (bm *BotMaid) slowDown(u *Update, wait time.Duration) {
+	bm.Reply(u, fmt.Sprintf(bm.Words["slowDown"], bm.At(u.User), wait.Round(time.Second)))
+}
+
+// penaltyMiddleware drops the updates of the users and the chats penalized
+// before the conversations, so that they could not answer them either.
+func (bm *BotMaid) penaltyMiddleware(next Handler) Handler {
+	return func(u *Update) {
+		if bm.IsPenalized(u) {
+			return
+		}
+
+		next(u)
+	}
+}
+
+func (bm *BotMaid) rateLimitMiddleware(next Handler) Handler {
+	return func(u *Update) {
+		if u.Message == nil || u.Message.Command == "" || u.User == nil || u.Chat == nil {
+			next(u)
+			return
+		}
+		if u.Type == "message_edited" && !bm.runsOnEdit(u.Message.Command) {
+			next(u)
+			return
+		}
+
+		if ok, wait := bm.takeToken(fmt.Sprintf("%v_user_%v", u.Bot.ID, u.User.ID), bm.Conf.RateLimit.User); !ok {
+			bm.penalize(u, "user", u.User.ID)
+			bm.slowDown(u, wait+bm.Conf.RateLimit.Penalty)
+			return
+		}
+
+		if ok, wait := bm.takeToken(fmt.Sprintf("%v_chat_%v", u.Bot.ID, u.Chat.ID), bm.Conf.RateLimit.Chat); !ok {
+			bm.penalize(u, "chat", u.Chat.ID)
+			bm.slowDown(u, wait+bm.Conf.RateLimit.Penalty)
+			return
+		}
+
+		if name, rl := bm.commandRateLimit(u.Message.Command); rl != nil {
+			if ok, wait := bm.takeToken(fmt.Sprintf("%v_command_%v_%v", u.Bot.ID, name, u.User.ID), rl); !ok {
+				bm.slowDown(u, wait)
+				return
+			}
+		}
+
+		next(u)
+	}
+}
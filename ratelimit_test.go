@@ -0,0 +1,130 @@
+package botmaid
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pelletier/go-toml"
+	"github.com/spf13/pflag"
+)
+
+func TestReadRateLimit(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		want    *RateLimit
+		wantErr bool
+	}{
+		{"missing", ``, nil, false},
+		{"default burst", `Interval = "1s"`, &RateLimit{Interval: time.Second, Burst: 1}, false},
+		{"burst", "Interval = \"1s\"\nBurst = 3", &RateLimit{Interval: time.Second, Burst: 3}, false},
+		{"missing interval", `Burst = 3`, nil, true},
+		{"zero interval", `Interval = "0s"`, nil, true},
+		{"negative interval", `Interval = "-1s"`, nil, true},
+		{"zero burst", "Interval = \"1s\"\nBurst = 0", nil, true},
+		{"invalid burst", "Interval = \"1s\"\nBurst = \"3\"", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := ""
+			if tt.config != "" {
+				config = "[RateLimit.User]\n" + tt.config
+			}
+			conf, err := toml.Load(config)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := readRateLimit(conf, "RateLimit.User")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if tt.want == nil && got != nil || tt.want != nil && (got == nil || *got != *tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadRateLimitConfigPenalty(t *testing.T) {
+	tests := []struct {
+		name    string
+		penalty string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"default", "", time.Minute, false},
+		{"set", "30s", 30 * time.Second, false},
+		{"zero", "0s", 0, true},
+		{"negative", "-1m", 0, true},
+		{"invalid", "soon", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := ""
+			if tt.penalty != "" {
+				config = "[RateLimit]\nPenalty = \"" + tt.penalty + "\""
+			}
+			conf, err := toml.Load(config)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			bm := &BotMaid{
+				Conf: &botMaidConfig{},
+			}
+			err = bm.readRateLimitConfig(conf)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && bm.Conf.RateLimit.Penalty != tt.want {
+				t.Errorf("got penalty %v, want %v", bm.Conf.RateLimit.Penalty, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateLimitSkipsEdits(t *testing.T) {
+	h, err := NewHarness(`
+[RateLimit.User]
+Interval = "1h"
+Burst = 2
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.AddCommand(&Command{
+		Do: func(u *Update, f *pflag.FlagSet) bool {
+			h.Reply(u, "pong")
+			return true
+		},
+		Help: &Help{
+			Names: []string{"ping"},
+		},
+	})
+
+	edit := func() []string {
+		return h.Contents(h.Handle(&Update{
+			Type: "message_edited",
+			Chat: &Chat{ID: 100, Type: "group"},
+			User: &User{ID: 2},
+			Message: &Message{
+				Type:    "Text",
+				Content: "/ping",
+			},
+		}))
+	}
+
+	if got, want := h.Contents(h.Send(100, 2, "/ping")), []string{"pong"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("ping: got %q, want %q", got, want)
+	}
+	if got := edit(); len(got) != 0 {
+		t.Fatalf("edited ping: got %q, want none", got)
+	}
+	if got, want := h.Contents(h.Send(100, 2, "/ping")), []string{"pong"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("ping after an edit: got %q, want %q", got, want)
+	}
+}
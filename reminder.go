@@ -0,0 +1,449 @@
+package botmaid
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// Reminder is a message scheduled by a user, it is sent once at Time if Cron
+// is empty, or at the times matching Cron in Location otherwise.
+type Reminder struct {
+	ID       int64
+	BotID    string
+	ChatID   int64
+	ChatType string
+	UserID   int64
+	UserName string
+	NickName string
+	Text     string
+	Time     time.Time `json:",omitempty"`
+	Cron     string    `json:",omitempty"`
+	Location string    `json:",omitempty"`
+}
+
+func reminderKey(botID string, chatID int64) string {
+	return fmt.Sprintf("reminder_%v_%v", botID, chatID)
+}
+
+func reminderChatsKey(botID string) string {
+	return "reminder_chats_" + botID
+}
+
+func (r *Reminder) key() string {
+	return fmt.Sprintf("%v_%v", r.BotID, r.ID)
+}
+
+func (r *Reminder) next(after time.Time) time.Time {
+	if r.Cron == "" {
+		if r.Time.After(after) {
+			return r.Time
+		}
+		return time.Time{}
+	}
+
+	s, err := ParseCron(r.Cron)
+	if err != nil {
+		return time.Time{}
+	}
+	loc, err := time.LoadLocation(r.Location)
+	if err != nil {
+		loc = time.Local
+	}
+	return s.Next(after.In(loc))
+}
+
+// scheduledReminder is a reminder armed in the scheduler with the next time
+// to deliver it.
+type scheduledReminder struct {
+	r    *Reminder
+	next time.Time
+}
+
+// AddReminder saves the reminder with a new ID and arms it if the BotMaid is
+// running.
+func (bm *BotMaid) AddReminder(r *Reminder) error {
+	id, err := bm.Store.Incr("reminder_id_" + r.BotID)
+	if err != nil {
+		return err
+	}
+	r.ID = id
+
+	bs, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	err = bm.Store.HSet(reminderKey(r.BotID, r.ChatID), strconv.FormatInt(r.ID, 10), string(bs))
+	if err != nil {
+		return err
+	}
+	err = bm.Store.SAdd(reminderChatsKey(r.BotID), r.ChatID)
+	if err != nil {
+		return err
+	}
+
+	bm.mu.Lock()
+	running := bm.cancel != nil && bm.ctx.Err() == nil
+	bm.mu.Unlock()
+	if running {
+		bm.armReminder(r)
+	}
+	return nil
+}
+
+// RemoveReminder removes the reminder and stops it.
+func (bm *BotMaid) RemoveReminder(r *Reminder) error {
+	bm.remindersMu.Lock()
+	delete(bm.reminders, r.key())
+	bm.remindersMu.Unlock()
+
+	return bm.Store.HDel(reminderKey(r.BotID, r.ChatID), strconv.FormatInt(r.ID, 10))
+}
+
+// Reminders returns the reminders of the chat of the bot sorted by ID.
+func (bm *BotMaid) Reminders(botID string, chatID int64) []*Reminder {
+	rs := []*Reminder{}
+
+	m, _ := bm.Store.HGetAll(reminderKey(botID, chatID))
+	for _, v := range m {
+		r := &Reminder{}
+		if json.Unmarshal([]byte(v), r) != nil {
+			continue
+		}
+		rs = append(rs, r)
+	}
+
+	sort.Slice(rs, func(i, j int) bool {
+		return rs[i].ID < rs[j].ID
+	})
+	return rs
+}
+
+func (bm *BotMaid) deliverReminder(r *Reminder) {
+	b, ok := bm.Bots[r.BotID]
+	if !ok {
+		return
+	}
+
+	u := &Update{
+		Bot: b,
+		Chat: &Chat{
+			ID:   r.ChatID,
+			Type: r.ChatType,
+		},
+	}
+	u.User = &User{
+		ID:       r.UserID,
+		UserName: r.UserName,
+		NickName: r.NickName,
+		Update:   u,
+	}
+
+	(*b.API).Push(&Update{
+		Message: &Message{
+			Content: fmt.Sprintf(bm.Words["reminderFormat"], bm.At(u.User), r.Text),
+		},
+		Chat: u.Chat,
+	})
+}
+
+// armReminder puts the reminder into the scheduler, a reminder missed while
+// the bot was down is delivered at once.
+func (bm *BotMaid) armReminder(r *Reminder) {
+	next := r.next(time.Now())
+	if r.Cron == "" && !r.Time.IsZero() {
+		next = r.Time
+	}
+	if next.IsZero() {
+		bm.RemoveReminder(r)
+		return
+	}
+
+	bm.remindersMu.Lock()
+	bm.reminders[r.key()] = &scheduledReminder{
+		r:    r,
+		next: next,
+	}
+	bm.remindersMu.Unlock()
+
+	select {
+	case bm.remindersWake <- struct{}{}:
+	default:
+	}
+}
+
+// runReminders delivers the reminders armed at their times until the context
+// is done.
+func (bm *BotMaid) runReminders(ctx context.Context) {
+	for {
+		now := time.Now()
+		due := []*scheduledReminder{}
+		wait := time.Duration(-1)
+
+		bm.remindersMu.Lock()
+		for k, sr := range bm.reminders {
+			if !sr.next.After(now) {
+				due = append(due, sr)
+				sr.next = sr.r.next(now)
+				if sr.next.IsZero() {
+					delete(bm.reminders, k)
+					continue
+				}
+			}
+			if d := sr.next.Sub(now); wait < 0 || d < wait {
+				wait = d
+			}
+		}
+		bm.remindersMu.Unlock()
+
+		for _, sr := range due {
+			bm.deliverReminder(sr.r)
+			if sr.next.IsZero() {
+				bm.RemoveReminder(sr.r)
+			}
+		}
+
+		var timer *time.Timer
+		var fire <-chan time.Time
+		if wait >= 0 {
+			timer = time.NewTimer(wait)
+			fire = timer.C
+		}
+
+		select {
+		case <-fire:
+		case <-bm.remindersWake:
+		case <-ctx.Done():
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// loadReminders arms the reminders saved and starts the scheduler of them.
+func (bm *BotMaid) loadReminders(ctx context.Context) {
+	bm.remindersMu.Lock()
+	bm.reminders = map[string]*scheduledReminder{}
+	bm.remindersMu.Unlock()
+
+	for _, b := range bm.Bots {
+		cs, _ := bm.Store.SMembers(reminderChatsKey(b.ID))
+		for _, c := range cs {
+			id, err := strconv.ParseInt(c, 10, 64)
+			if err != nil {
+				continue
+			}
+
+			rs := bm.Reminders(b.ID, id)
+			if len(rs) == 0 {
+				bm.Store.SRem(reminderChatsKey(b.ID), c)
+				continue
+			}
+			for _, r := range rs {
+				bm.armReminder(r)
+			}
+		}
+	}
+
+	bm.wg.Add(1)
+	go func() {
+		defer bm.wg.Done()
+
+		bm.runReminders(ctx)
+	}()
+}
+
+// parseSchedule parses the schedules like "daily 08:00", "weekdays 08:00",
+// "weekly MON 08:00", "monthly 1 08:00" and "cron <cron expression>" in the
+// args into a cron expression, and returns the rest of the args.
+func parseSchedule(args []string) (string, []string, error) {
+	if len(args) < 2 {
+		return "", nil, fmt.Errorf("Missing schedule")
+	}
+
+	if args[0] == "cron" {
+		_, err := ParseCron(args[1])
+		return args[1], args[2:], err
+	}
+
+	at := func(s string) (int, int, error) {
+		t, err := time.Parse("15:04", s)
+		if err != nil {
+			return 0, 0, fmt.Errorf("Invalid time %v", s)
+		}
+		return t.Hour(), t.Minute(), nil
+	}
+
+	switch args[0] {
+	case "daily", "weekdays":
+		h, m, err := at(args[1])
+		if err != nil {
+			return "", nil, err
+		}
+		if args[0] == "weekdays" {
+			return fmt.Sprintf("%v %v * * 1-5", m, h), args[2:], nil
+		}
+		return fmt.Sprintf("%v %v * * *", m, h), args[2:], nil
+	case "weekly", "monthly":
+		if len(args) < 3 {
+			return "", nil, fmt.Errorf("Missing time")
+		}
+		h, m, err := at(args[2])
+		if err != nil {
+			return "", nil, err
+		}
+		spec := fmt.Sprintf("%v %v * * %v", m, h, args[1])
+		if args[0] == "monthly" {
+			spec = fmt.Sprintf("%v %v %v * *", m, h, args[1])
+		}
+		_, err = ParseCron(spec)
+		return spec, args[3:], err
+	}
+
+	return "", nil, fmt.Errorf("Unknown schedule %v", args[0])
+}
+
+func (bm *BotMaid) reminderCommand(u *Update, f *pflag.FlagSet) bool {
+	if len(f.Args()) < 2 {
+		return false
+	}
+
+	if f.Args()[1] == "list" && len(f.Args()) == 2 {
+		s := ""
+		for _, r := range bm.Reminders(u.Bot.ID, u.Chat.ID) {
+			if r.UserID != u.User.ID {
+				continue
+			}
+			when := r.Cron
+			if when == "" {
+				when = r.Time.Format("2006-01-02 15:04:05")
+			} else if r.Location != "" {
+				when += " " + r.Location
+			}
+			s += fmt.Sprintf(bm.Words["reminderListItem"], r.ID, when, r.Text)
+		}
+
+		if s == "" {
+			bm.Reply(u, fmt.Sprintf(bm.Words["reminderListEmpty"], bm.At(u.User)))
+			return true
+		}
+		bm.Reply(u, fmt.Sprintf(bm.Words["reminderList"], bm.At(u.User), s))
+		return true
+	}
+
+	if f.Args()[1] == "cancel" && len(f.Args()) == 3 {
+		id, _ := strconv.ParseInt(strings.TrimPrefix(f.Args()[2], "#"), 10, 64)
+		for _, r := range bm.Reminders(u.Bot.ID, u.Chat.ID) {
+			if r.ID != id {
+				continue
+			}
+			if r.UserID != u.User.ID && !bm.CheckPermission(u, "moderator", f.Args()[0]) {
+				return true
+			}
+
+			bm.RemoveReminder(r)
+			bm.Reply(u, fmt.Sprintf(bm.Words["reminderCancelled"], r.ID))
+			return true
+		}
+
+		bm.Reply(u, fmt.Sprintf(bm.Words["unknownReminder"], bm.At(u.User), f.Args()[2]))
+		return true
+	}
+
+	return false
+}
+
+func (bm *BotMaid) newReminder(u *Update, text []string) *Reminder {
+	return &Reminder{
+		BotID:    u.Bot.ID,
+		ChatID:   u.Chat.ID,
+		ChatType: u.Chat.Type,
+		UserID:   u.User.ID,
+		UserName: u.User.UserName,
+		NickName: u.User.NickName,
+		Text:     strings.Join(text, " "),
+	}
+}
+
+func (bm *BotMaid) RemindCommandDo(u *Update, f *pflag.FlagSet) bool {
+	if u.Chat == nil || u.User == nil {
+		return false
+	}
+	if bm.reminderCommand(u, f) {
+		return true
+	}
+	if len(f.Args()) < 3 {
+		return false
+	}
+
+	d, err := parseDuration(f.Args()[1])
+	if err != nil || d <= 0 {
+		bm.Reply(u, fmt.Sprintf(bm.Words["invalidDuration"], bm.At(u.User), f.Args()[1]))
+		return true
+	}
+
+	r := bm.newReminder(u, f.Args()[2:])
+	r.Time = time.Now().Add(d).Truncate(time.Second)
+
+	err = bm.AddReminder(r)
+	if err != nil {
+		return true
+	}
+
+	bm.Reply(u, fmt.Sprintf(bm.Words["reminderSet"], bm.At(u.User), r.Time.Format("2006-01-02 15:04:05"), r.ID))
+	return true
+}
+
+func (bm *BotMaid) ScheduleCommandDo(u *Update, f *pflag.FlagSet) bool {
+	if u.Chat == nil || u.User == nil {
+		return false
+	}
+	if bm.reminderCommand(u, f) {
+		return true
+	}
+
+	spec, text, err := parseSchedule(f.Args()[1:])
+	if err != nil {
+		bm.Reply(u, fmt.Sprintf(bm.Words["invalidSchedule"], bm.At(u.User), strings.Join(f.Args()[1:], " ")))
+		return true
+	}
+	if len(text) == 0 {
+		return false
+	}
+
+	loc := bm.Conf.ReminderLocation
+	if z, _ := f.GetString("zone"); z != "" {
+		loc, err = time.LoadLocation(z)
+		if err != nil {
+			bm.Reply(u, fmt.Sprintf(bm.Words["invalidZone"], bm.At(u.User), z))
+			return true
+		}
+	}
+
+	r := bm.newReminder(u, text)
+	r.Cron = spec
+	r.Location = loc.String()
+
+	err = bm.AddReminder(r)
+	if err != nil {
+		return true
+	}
+
+	bm.Reply(u, fmt.Sprintf(bm.Words["scheduleSet"], bm.At(u.User), r.ID, r.next(time.Now()).Format("2006-01-02 15:04:05 MST")))
+	return true
+}
+
+func (bm *BotMaid) ScheduleCommandHelpSetFlag(f *pflag.FlagSet) {
+	f.StringP("zone", "z", "", bm.Words["scheduleZoneHelp"])
+}
@@ -0,0 +1,220 @@
+package botmaid
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pelletier/go-toml"
+	"github.com/spf13/pflag"
+)
+
+// The levels of the built-in roles, a user having a role also has the roles
+// with lower levels. Masters are owners and administrators of a chat on the
+// platform are moderators of the chat.
+var defaultRoles = map[string]int{
+	"owner":     100,
+	"admin":     50,
+	"moderator": 10,
+}
+
+type chatAdminChecker interface {
+	isChatAdmin(c *Chat, u *User) (bool, error)
+}
+
+func (bm *BotMaid) readRoleConfig(conf *toml.Tree) error {
+	bm.Roles = map[string]int{}
+	for k, v := range defaultRoles {
+		bm.Roles[k] = v
+	}
+
+	t, ok := conf.Get("Role").(*toml.Tree)
+	if !ok {
+		return nil
+	}
+
+	for _, k := range t.Keys() {
+		l, ok := t.Get(k).(int64)
+		if !ok {
+			return fmt.Errorf("Invalid level of role %v", k)
+		}
+		bm.Roles[k] = int(l)
+	}
+
+	return nil
+}
+
+func roleKey(b *Bot, id int64) string {
+	return fmt.Sprintf("role_%v_%v", b.ID, id)
+}
+
+// GrantRole grants a role to a user of the bot, the role only works in the
+// chat if chatID is not 0.
+func (bm *BotMaid) GrantRole(b *Bot, userID int64, role string, chatID int64) error {
+	m := role
+	if chatID != 0 {
+		m = fmt.Sprintf("%v|%v", role, chatID)
+	}
+
+	return bm.Store.SAdd(roleKey(b, userID), m)
+}
+
+// RevokeRole revokes a role granted by GrantRole.
+func (bm *BotMaid) RevokeRole(b *Bot, userID int64, role string, chatID int64) error {
+	m := role
+	if chatID != 0 {
+		m = fmt.Sprintf("%v|%v", role, chatID)
+	}
+
+	return bm.Store.SRem(roleKey(b, userID), m)
+}
+
+// UserRoles returns the roles of a user in the chat of the update, including the
+// granted ones and the ones from the master set, but not the ones from the
+// platform.
+func (bm *BotMaid) UserRoles(u *User) []string {
+	rs := []string{}
+
+	if bm.IsMaster(u) {
+		rs = append(rs, "owner")
+	}
+
+	ms, _ := bm.Store.SMembers(roleKey(u.Update.Bot, u.ID))
+	for _, m := range ms {
+		args := strings.SplitN(m, "|", 2)
+		if len(args) == 2 && (u.Update.Chat == nil || args[1] != strconv.FormatInt(u.Update.Chat.ID, 10)) {
+			continue
+		}
+		if !Contains(rs, args[0]) {
+			rs = append(rs, args[0])
+		}
+	}
+
+	sort.Strings(rs)
+	return rs
+}
+
+func (bm *BotMaid) roleLevel(rs []string) int {
+	l := 0
+	for _, r := range rs {
+		if bm.Roles[r] > l {
+			l = bm.Roles[r]
+		}
+	}
+	return l
+}
+
+// HasRole checks if a user has the role in the chat of the update.
+func (bm *BotMaid) HasRole(u *User, role string) bool {
+	rs := bm.UserRoles(u)
+	if Contains(rs, role) {
+		return true
+	}
+
+	l, ok := bm.Roles[role]
+	if !ok || l <= 0 {
+		return false
+	}
+	if bm.roleLevel(rs) >= l {
+		return true
+	}
+
+	if bm.Roles["moderator"] < l || u.Update.Chat == nil {
+		return false
+	}
+	if c, ok := (*u.Update.Bot.API).(chatAdminChecker); ok {
+		is, err := c.isChatAdmin(u.Update.Chat, u)
+		return err == nil && is
+	}
+	return false
+}
+
+// CheckPermission checks if the user of the update has the role, and replies
+// that the user has no permission to use the command with the name if not.
+// It fails without a reply if the update has no user.
+func (bm *BotMaid) CheckPermission(u *Update, role, name string) bool {
+	if u.User == nil {
+		return false
+	}
+	if bm.HasRole(u.User, role) {
+		return true
+	}
+
+	bm.Reply(u, fmt.Sprintf(bm.Words["noPermission"], bm.At(u.User), name))
+	return false
+}
+
+func (bm *BotMaid) RoleCommandDo(u *Update, f *pflag.FlagSet) bool {
+	if len(f.Args()) == 1 || (len(f.Args()) <= 3 && f.Args()[1] == "list") {
+		user := u.User
+		name := bm.At(u.User)
+		if len(f.Args()) == 3 {
+			id, err := (*u.Bot.API).ParseUserID(u, f.Args()[2])
+			if err != nil {
+				bm.Reply(u, fmt.Sprintf(bm.Words["invalidUser"], bm.At(u.User), f.Args()[2]))
+				return true
+			}
+			user = &User{
+				ID:     id,
+				Update: u,
+			}
+			name = f.Args()[2]
+		}
+
+		rs := bm.UserRoles(user)
+		if len(rs) == 0 {
+			bm.Reply(u, fmt.Sprintf(bm.Words["roleListEmpty"], name))
+			return true
+		}
+
+		bm.Reply(u, fmt.Sprintf(bm.Words["roleList"], name, ListToString(rs, bm.Words["subEntriesFormat"], bm.Words["subEntriesSeparator"], bm.Words["subEntriesAnd"])))
+		return true
+	}
+
+	if len(f.Args()) != 4 || (f.Args()[1] != "grant" && f.Args()[1] != "revoke") {
+		return false
+	}
+
+	if !bm.CheckPermission(u, "admin", "role") {
+		return true
+	}
+
+	id, err := (*u.Bot.API).ParseUserID(u, f.Args()[2])
+	if err != nil {
+		bm.Reply(u, fmt.Sprintf(bm.Words["invalidUser"], bm.At(u.User), f.Args()[2]))
+		return true
+	}
+
+	role := f.Args()[3]
+	if _, ok := bm.Roles[role]; !ok {
+		bm.Reply(u, fmt.Sprintf(bm.Words["unknownRole"], bm.At(u.User), role))
+		return true
+	}
+
+	if !bm.HasRole(u.User, "owner") && bm.Roles[role] >= bm.roleLevel(bm.UserRoles(u.User)) {
+		bm.Reply(u, fmt.Sprintf(bm.Words["noPermission"], bm.At(u.User), "role"))
+		return true
+	}
+
+	chatID := int64(0)
+	scope := ""
+	if chat, _ := f.GetBool("chat"); chat {
+		chatID = u.Chat.ID
+		scope = bm.Words["roleChatScope"]
+	}
+
+	if f.Args()[1] == "grant" {
+		bm.GrantRole(u.Bot, id, role, chatID)
+		bm.Reply(u, fmt.Sprintf(bm.Words["roleGranted"], f.Args()[2], role, scope))
+		return true
+	}
+
+	bm.RevokeRole(u.Bot, id, role, chatID)
+	bm.Reply(u, fmt.Sprintf(bm.Words["roleRevoked"], f.Args()[2], role, scope))
+	return true
+}
+
+func (bm *BotMaid) RoleCommandHelpSetFlag(f *pflag.FlagSet) {
+	f.BoolP("chat", "c", false, bm.Words["roleChatHelp"])
+}
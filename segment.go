@@ -0,0 +1,102 @@
+package botmaid
+
+// Segment is a typed part of a message, adapters render segments into their
+// native formats and parse incoming messages into segments.
+//
+// Type is one of "Text", "Mention", "Image", "Audio", "Sticker", "Reply",
+// "Link" and "Emoji".
+// Text is the text of a Text, the title of a Link or the emoji of an Emoji.
+// File is the URL, the path or the file ID of an Image, an Audio or a Sticker.
+// URL is the URL of a Link.
+// User is the mentioned user of a Mention.
+// ID is the message ID of a Reply or the platform ID of an Emoji.
+type Segment struct {
+	Type string
+
+	Text string
+	File string
+	URL  string
+	User *User
+	ID   int64
+}
+
+// TextSegment returns a segment of plain text.
+func TextSegment(s string) *Segment {
+	return &Segment{
+		Type: "Text",
+		Text: s,
+	}
+}
+
+// MentionSegment returns a segment mentioning the user.
+func MentionSegment(u *User) *Segment {
+	return &Segment{
+		Type: "Mention",
+		User: u,
+	}
+}
+
+// ImageSegment returns a segment of an image from a URL or a path.
+func ImageSegment(file string) *Segment {
+	return &Segment{
+		Type: "Image",
+		File: file,
+	}
+}
+
+// AudioSegment returns a segment of an audio from a URL or a path.
+func AudioSegment(file string) *Segment {
+	return &Segment{
+		Type: "Audio",
+		File: file,
+	}
+}
+
+// StickerSegment returns a segment of a sticker from a URL or a path.
+func StickerSegment(file string) *Segment {
+	return &Segment{
+		Type: "Sticker",
+		File: file,
+	}
+}
+
+// ReplySegment returns a segment quoting the message.
+func ReplySegment(m *Message) *Segment {
+	return &Segment{
+		Type: "Reply",
+		ID:   m.ID,
+	}
+}
+
+// LinkSegment returns a segment of a link with a title.
+func LinkSegment(url, title string) *Segment {
+	return &Segment{
+		Type: "Link",
+		Text: title,
+		URL:  url,
+	}
+}
+
+// EmojiSegment returns a segment of an emoji, id is the platform ID of the
+// emoji and could be 0 if the emoji is a unicode one.
+func EmojiSegment(emoji string, id int64) *Segment {
+	return &Segment{
+		Type: "Emoji",
+		Text: emoji,
+		ID:   id,
+	}
+}
+
+func isMediaSegment(s *Segment) bool {
+	return s.Type == "Image" || s.Type == "Audio" || s.Type == "Sticker"
+}
+
+func mentionName(u *User) string {
+	if u.NickName != "" {
+		return u.NickName
+	}
+	if u.UserName != "" {
+		return u.UserName
+	}
+	return storeString(u.ID)
+}
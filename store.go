@@ -0,0 +1,70 @@
+package botmaid
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Store is an interface including some common behaviors for storages.
+//
+// The semantics of the methods follow the Redis commands with the same names,
+// so that a Store could be backed by Redis, the memory or a file.
+//
+// TakeToken takes a token from the token bucket of the key atomically, the
+// bucket holds at most burst tokens and gets a new token every interval. It
+// returns whether a token is taken and the time to wait for the next token.
+type Store interface {
+	Get(key string) (string, error)
+	Set(key string, value interface{}, expiration time.Duration) error
+	Del(keys ...string) error
+	Incr(key string) (int64, error)
+	Expire(key string, expiration time.Duration) error
+
+	SAdd(key string, members ...interface{}) error
+	SRem(key string, members ...interface{}) error
+	SIsMember(key string, member interface{}) (bool, error)
+	SMembers(key string) ([]string, error)
+
+	HSet(key, field string, value interface{}) error
+	HGet(key, field string) (string, error)
+	HDel(key string, fields ...string) error
+	HGetAll(key string) (map[string]string, error)
+
+	RPush(key string, values ...interface{}) error
+	LRange(key string, start, stop int64) ([]string, error)
+	LRem(key string, count int64, value interface{}) error
+
+	TakeToken(key string, interval time.Duration, burst int64) (bool, time.Duration, error)
+
+	Ping() error
+	Close() error
+}
+
+// ErrStoreNil is returned by a Store when the key or the field does not exist.
+var ErrStoreNil = errors.New("Store: Key does not exist")
+
+type botmaidStoreConfig struct {
+	Type string
+	Path string
+}
+
+func storeString(v interface{}) string {
+	return fmt.Sprint(v)
+}
+
+// gcra runs the generic cell rate algorithm, which is equivalent to a token
+// bucket, with the theoretical arrival time of the bucket. It returns the new
+// theoretical arrival time and the time to wait if the token is not taken.
+func gcra(now, tat time.Time, interval time.Duration, burst int64) (time.Time, time.Duration) {
+	if tat.Before(now) {
+		tat = now
+	}
+
+	next := tat.Add(interval)
+	allow := next.Add(-interval * time.Duration(burst))
+	if now.Before(allow) {
+		return tat, allow.Sub(now)
+	}
+	return next, 0
+}
@@ -0,0 +1,152 @@
+package botmaid
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// StoreFile is a StoreMemory which saves everything into a file after every
+// modification, so that the data could survive restarts without a Redis
+// server. Expired keys are dropped before saving, and the file is not written
+// if the data did not change. The token buckets are not saved until the next
+// modification.
+type StoreFile struct {
+	*StoreMemory
+
+	Path string
+
+	saveMu sync.Mutex
+	saved  []byte
+}
+
+// NewStoreFile creates a StoreFile and loads the data from the file if it
+// exists.
+func NewStoreFile(path string) (*StoreFile, error) {
+	s := &StoreFile{
+		StoreMemory: NewStoreMemory(),
+		Path:        path,
+	}
+
+	raw, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Load store file: %v", err)
+	}
+
+	s.saved = raw
+	err = json.Unmarshal(raw, &s.data)
+	if err != nil {
+		return nil, fmt.Errorf("Load store file: %v", err)
+	}
+	if s.data.KV == nil {
+		s.data.KV = map[string]string{}
+	}
+	if s.data.Sets == nil {
+		s.data.Sets = map[string]map[string]bool{}
+	}
+	if s.data.Hashes == nil {
+		s.data.Hashes = map[string]map[string]string{}
+	}
+	if s.data.Lists == nil {
+		s.data.Lists = map[string][]string{}
+	}
+	if s.data.Expires == nil {
+		s.data.Expires = map[string]time.Time{}
+	}
+
+	return s, nil
+}
+
+func (s *StoreFile) save(err error) error {
+	if err != nil {
+		return err
+	}
+
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+
+	s.mu.Lock()
+	s.purge()
+	raw, err := json.Marshal(&s.data)
+	s.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("Save store file: %v", err)
+	}
+	if bytes.Equal(raw, s.saved) {
+		return nil
+	}
+
+	err = ioutil.WriteFile(s.Path+".tmp", raw, 0600)
+	if err != nil {
+		return fmt.Errorf("Save store file: %v", err)
+	}
+	err = os.Rename(s.Path+".tmp", s.Path)
+	if err != nil {
+		return fmt.Errorf("Save store file: %v", err)
+	}
+	s.saved = raw
+	return nil
+}
+
+// Set sets the value of the key, the key never expires if the expiration is 0.
+func (s *StoreFile) Set(key string, value interface{}, expiration time.Duration) error {
+	return s.save(s.StoreMemory.Set(key, value, expiration))
+}
+
+// Del deletes the keys.
+func (s *StoreFile) Del(keys ...string) error {
+	return s.save(s.StoreMemory.Del(keys...))
+}
+
+// Incr increases the integer value of the key by one.
+func (s *StoreFile) Incr(key string) (int64, error) {
+	i, err := s.StoreMemory.Incr(key)
+	return i, s.save(err)
+}
+
+// Expire sets the expiration of the key.
+func (s *StoreFile) Expire(key string, expiration time.Duration) error {
+	return s.save(s.StoreMemory.Expire(key, expiration))
+}
+
+// SAdd adds the members into the set.
+func (s *StoreFile) SAdd(key string, members ...interface{}) error {
+	return s.save(s.StoreMemory.SAdd(key, members...))
+}
+
+// SRem removes the members from the set.
+func (s *StoreFile) SRem(key string, members ...interface{}) error {
+	return s.save(s.StoreMemory.SRem(key, members...))
+}
+
+// HSet sets the field of the hash.
+func (s *StoreFile) HSet(key, field string, value interface{}) error {
+	return s.save(s.StoreMemory.HSet(key, field, value))
+}
+
+// HDel deletes the fields of the hash.
+func (s *StoreFile) HDel(key string, fields ...string) error {
+	return s.save(s.StoreMemory.HDel(key, fields...))
+}
+
+// RPush appends the values to the list.
+func (s *StoreFile) RPush(key string, values ...interface{}) error {
+	return s.save(s.StoreMemory.RPush(key, values...))
+}
+
+// LRem removes count occurrences of the value from the list.
+func (s *StoreFile) LRem(key string, count int64, value interface{}) error {
+	return s.save(s.StoreMemory.LRem(key, count, value))
+}
+
+// Close saves the data into the file.
+func (s *StoreFile) Close() error {
+	return s.save(nil)
+}
@@ -0,0 +1,365 @@
+package botmaid
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+type storeMemoryData struct {
+	KV      map[string]string
+	Sets    map[string]map[string]bool
+	Hashes  map[string]map[string]string
+	Lists   map[string][]string
+	Expires map[string]time.Time
+}
+
+// StoreMemory is a Store keeping everything in the memory of the process.
+type StoreMemory struct {
+	mu   sync.Mutex
+	data storeMemoryData
+}
+
+// NewStoreMemory creates an empty StoreMemory.
+func NewStoreMemory() *StoreMemory {
+	s := &StoreMemory{}
+	s.reset()
+	return s
+}
+
+func (s *StoreMemory) reset() {
+	s.data = storeMemoryData{
+		KV:      map[string]string{},
+		Sets:    map[string]map[string]bool{},
+		Hashes:  map[string]map[string]string{},
+		Lists:   map[string][]string{},
+		Expires: map[string]time.Time{},
+	}
+}
+
+func (s *StoreMemory) del(key string) {
+	delete(s.data.KV, key)
+	delete(s.data.Sets, key)
+	delete(s.data.Hashes, key)
+	delete(s.data.Lists, key)
+	delete(s.data.Expires, key)
+}
+
+// prune drops the expiration of a key none of the maps hold anymore.
+func (s *StoreMemory) prune(key string) {
+	if !s.exists(key) {
+		delete(s.data.Expires, key)
+	}
+}
+
+func (s *StoreMemory) expire(key string) {
+	if t, ok := s.data.Expires[key]; ok && !time.Now().Before(t) {
+		s.del(key)
+	}
+}
+
+// purge deletes all expired keys.
+func (s *StoreMemory) purge() {
+	for key := range s.data.Expires {
+		s.expire(key)
+	}
+}
+
+func (s *StoreMemory) exists(key string) bool {
+	_, kv := s.data.KV[key]
+	_, set := s.data.Sets[key]
+	_, hash := s.data.Hashes[key]
+	_, list := s.data.Lists[key]
+	return kv || set || hash || list
+}
+
+// Get gets the value of the key.
+func (s *StoreMemory) Get(key string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expire(key)
+	v, ok := s.data.KV[key]
+	if !ok {
+		return "", ErrStoreNil
+	}
+	return v, nil
+}
+
+// Set sets the value of the key, the key never expires if the expiration is 0.
+func (s *StoreMemory) Set(key string, value interface{}, expiration time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.del(key)
+	s.data.KV[key] = storeString(value)
+	if expiration > 0 {
+		s.data.Expires[key] = time.Now().Add(expiration)
+	}
+	return nil
+}
+
+// Del deletes the keys.
+func (s *StoreMemory) Del(keys ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, k := range keys {
+		s.del(k)
+	}
+	return nil
+}
+
+// Incr increases the integer value of the key by one.
+func (s *StoreMemory) Incr(key string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expire(key)
+	i := int64(0)
+	if v, ok := s.data.KV[key]; ok {
+		var err error
+		i, err = strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return 0, err
+		}
+	}
+	i++
+	s.data.KV[key] = strconv.FormatInt(i, 10)
+	return i, nil
+}
+
+// Expire sets the expiration of the key.
+func (s *StoreMemory) Expire(key string, expiration time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expire(key)
+	if s.exists(key) {
+		s.data.Expires[key] = time.Now().Add(expiration)
+	}
+	return nil
+}
+
+// SAdd adds the members into the set.
+func (s *StoreMemory) SAdd(key string, members ...interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expire(key)
+	if _, ok := s.data.Sets[key]; !ok {
+		s.data.Sets[key] = map[string]bool{}
+	}
+	for _, m := range members {
+		s.data.Sets[key][storeString(m)] = true
+	}
+	return nil
+}
+
+// SRem removes the members from the set.
+func (s *StoreMemory) SRem(key string, members ...interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expire(key)
+	for _, m := range members {
+		delete(s.data.Sets[key], storeString(m))
+	}
+	if len(s.data.Sets[key]) == 0 {
+		delete(s.data.Sets, key)
+		s.prune(key)
+	}
+	return nil
+}
+
+// SIsMember checks if the member is in the set.
+func (s *StoreMemory) SIsMember(key string, member interface{}) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expire(key)
+	return s.data.Sets[key][storeString(member)], nil
+}
+
+// SMembers returns all members of the set.
+func (s *StoreMemory) SMembers(key string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expire(key)
+	ms := []string{}
+	for m := range s.data.Sets[key] {
+		ms = append(ms, m)
+	}
+	return ms, nil
+}
+
+// HSet sets the field of the hash.
+func (s *StoreMemory) HSet(key, field string, value interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expire(key)
+	if _, ok := s.data.Hashes[key]; !ok {
+		s.data.Hashes[key] = map[string]string{}
+	}
+	s.data.Hashes[key][field] = storeString(value)
+	return nil
+}
+
+// HGet gets the field of the hash.
+func (s *StoreMemory) HGet(key, field string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expire(key)
+	v, ok := s.data.Hashes[key][field]
+	if !ok {
+		return "", ErrStoreNil
+	}
+	return v, nil
+}
+
+// HDel deletes the fields of the hash.
+func (s *StoreMemory) HDel(key string, fields ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expire(key)
+	for _, f := range fields {
+		delete(s.data.Hashes[key], f)
+	}
+	if len(s.data.Hashes[key]) == 0 {
+		delete(s.data.Hashes, key)
+		s.prune(key)
+	}
+	return nil
+}
+
+// HGetAll returns all fields of the hash.
+func (s *StoreMemory) HGetAll(key string) (map[string]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expire(key)
+	m := map[string]string{}
+	for k, v := range s.data.Hashes[key] {
+		m[k] = v
+	}
+	return m, nil
+}
+
+// RPush appends the values to the list.
+func (s *StoreMemory) RPush(key string, values ...interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expire(key)
+	for _, v := range values {
+		s.data.Lists[key] = append(s.data.Lists[key], storeString(v))
+	}
+	return nil
+}
+
+// LRange returns the elements of the list in [start..stop].
+func (s *StoreMemory) LRange(key string, start, stop int64) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expire(key)
+	l := s.data.Lists[key]
+	n := int64(len(l))
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= n {
+		stop = n - 1
+	}
+	if start > stop {
+		return []string{}, nil
+	}
+	return append([]string{}, l[start:stop+1]...), nil
+}
+
+// LRem removes count occurrences of the value from the list.
+func (s *StoreMemory) LRem(key string, count int64, value interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expire(key)
+	l := s.data.Lists[key]
+	v := storeString(value)
+	removed := int64(0)
+	if count >= 0 {
+		ret := []string{}
+		for _, e := range l {
+			if e == v && (count == 0 || removed < count) {
+				removed++
+				continue
+			}
+			ret = append(ret, e)
+		}
+		l = ret
+	} else {
+		ret := []string{}
+		for i := len(l) - 1; i >= 0; i-- {
+			if l[i] == v && removed < -count {
+				removed++
+				continue
+			}
+			ret = append([]string{l[i]}, ret...)
+		}
+		l = ret
+	}
+
+	if len(l) == 0 {
+		delete(s.data.Lists, key)
+		s.prune(key)
+		return nil
+	}
+	s.data.Lists[key] = l
+	return nil
+}
+
+// TakeToken takes a token from the token bucket of the key.
+func (s *StoreMemory) TakeToken(key string, interval time.Duration, burst int64) (bool, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+
+	s.expire(key)
+	tat := now
+	if v, ok := s.data.KV[key]; ok {
+		i, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return false, 0, err
+		}
+		tat = time.Unix(0, i)
+	}
+
+	tat, wait := gcra(now, tat, interval, burst)
+	if wait != 0 {
+		return false, wait, nil
+	}
+
+	s.data.KV[key] = strconv.FormatInt(tat.UnixNano(), 10)
+	s.data.Expires[key] = tat
+	return true, 0, nil
+}
+
+// Ping always succeeds for a StoreMemory.
+func (s *StoreMemory) Ping() error {
+	return nil
+}
+
+// Close does nothing for a StoreMemory.
+func (s *StoreMemory) Close() error {
+	return nil
+}
@@ -0,0 +1,150 @@
+package botmaid
+
+import (
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// takeTokenScript runs the generic cell rate algorithm in microseconds, which
+// keeps the numbers precise in Lua.
+var takeTokenScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local interval = tonumber(ARGV[2])
+local burst = tonumber(ARGV[3])
+
+local tat = tonumber(redis.call("GET", KEYS[1]) or now)
+if tat < now then
+	tat = now
+end
+
+local next = tat + interval
+local allow = next - interval * burst
+if now < allow then
+	return allow - now
+end
+
+redis.call("SET", KEYS[1], string.format("%.0f", next), "PX", math.ceil((next - now) / 1000))
+return 0
+`)
+
+// StoreRedis is a Store backed by a Redis server.
+type StoreRedis struct {
+	Client *redis.Client
+}
+
+// NewStoreRedis creates a StoreRedis with the options of the Redis client.
+func NewStoreRedis(opt *redis.Options) *StoreRedis {
+	return &StoreRedis{
+		Client: redis.NewClient(opt),
+	}
+}
+
+func redisError(err error) error {
+	if err == redis.Nil {
+		return ErrStoreNil
+	}
+	return err
+}
+
+// Get gets the value of the key.
+func (s *StoreRedis) Get(key string) (string, error) {
+	v, err := s.Client.Get(key).Result()
+	return v, redisError(err)
+}
+
+// Set sets the value of the key, the key never expires if the expiration is 0.
+func (s *StoreRedis) Set(key string, value interface{}, expiration time.Duration) error {
+	return s.Client.Set(key, value, expiration).Err()
+}
+
+// Del deletes the keys.
+func (s *StoreRedis) Del(keys ...string) error {
+	return s.Client.Del(keys...).Err()
+}
+
+// Incr increases the integer value of the key by one.
+func (s *StoreRedis) Incr(key string) (int64, error) {
+	return s.Client.Incr(key).Result()
+}
+
+// Expire sets the expiration of the key.
+func (s *StoreRedis) Expire(key string, expiration time.Duration) error {
+	return s.Client.Expire(key, expiration).Err()
+}
+
+// SAdd adds the members into the set.
+func (s *StoreRedis) SAdd(key string, members ...interface{}) error {
+	return s.Client.SAdd(key, members...).Err()
+}
+
+// SRem removes the members from the set.
+func (s *StoreRedis) SRem(key string, members ...interface{}) error {
+	return s.Client.SRem(key, members...).Err()
+}
+
+// SIsMember checks if the member is in the set.
+func (s *StoreRedis) SIsMember(key string, member interface{}) (bool, error) {
+	return s.Client.SIsMember(key, member).Result()
+}
+
+// SMembers returns all members of the set.
+func (s *StoreRedis) SMembers(key string) ([]string, error) {
+	return s.Client.SMembers(key).Result()
+}
+
+// HSet sets the field of the hash.
+func (s *StoreRedis) HSet(key, field string, value interface{}) error {
+	return s.Client.HSet(key, field, value).Err()
+}
+
+// HGet gets the field of the hash.
+func (s *StoreRedis) HGet(key, field string) (string, error) {
+	v, err := s.Client.HGet(key, field).Result()
+	return v, redisError(err)
+}
+
+// HDel deletes the fields of the hash.
+func (s *StoreRedis) HDel(key string, fields ...string) error {
+	return s.Client.HDel(key, fields...).Err()
+}
+
+// HGetAll returns all fields of the hash.
+func (s *StoreRedis) HGetAll(key string) (map[string]string, error) {
+	return s.Client.HGetAll(key).Result()
+}
+
+// RPush appends the values to the list.
+func (s *StoreRedis) RPush(key string, values ...interface{}) error {
+	return s.Client.RPush(key, values...).Err()
+}
+
+// LRange returns the elements of the list in [start..stop].
+func (s *StoreRedis) LRange(key string, start, stop int64) ([]string, error) {
+	return s.Client.LRange(key, start, stop).Result()
+}
+
+// LRem removes count occurrences of the value from the list.
+func (s *StoreRedis) LRem(key string, count int64, value interface{}) error {
+	return s.Client.LRem(key, count, value).Err()
+}
+
+// TakeToken takes a token from the token bucket of the key, the bucket is
+// shared by all processes using the same Redis server.
+func (s *StoreRedis) TakeToken(key string, interval time.Duration, burst int64) (bool, time.Duration, error) {
+	wait, err := takeTokenScript.Run(s.Client, []string{key}, time.Now().UnixNano()/1000, int64(interval/time.Microsecond), burst).Int64()
+	if err != nil {
+		return false, 0, err
+	}
+	return wait == 0, time.Duration(wait) * time.Microsecond, nil
+}
+
+// Ping checks the connection to the Redis server.
+func (s *StoreRedis) Ping() error {
+	return s.Client.Ping().Err()
+}
+
+// Close closes the connection to the Redis server.
+func (s *StoreRedis) Close() error {
+	return s.Client.Close()
+}
@@ -0,0 +1,320 @@
+package botmaid
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testStores = map[string]func(t *testing.T, dir string) Store{
+	"Memory": func(t *testing.T, dir string) Store {
+		return NewStoreMemory()
+	},
+	"File": func(t *testing.T, dir string) Store {
+		s, err := NewStoreFile(filepath.Join(dir, "store.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	},
+}
+
+func testTempDir(t *testing.T) (string, func()) {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "botmaid_store_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, func() {
+		os.RemoveAll(dir)
+	}
+}
+
+func TestStoreGetSet(t *testing.T) {
+	for name, newStore := range testStores {
+		t.Run(name, func(t *testing.T) {
+			dir, clean := testTempDir(t)
+			defer clean()
+			s := newStore(t, dir)
+
+			_, err := s.Get("key")
+			if err != ErrStoreNil {
+				t.Fatalf("Get of a missing key: got error %v, want %v", err, ErrStoreNil)
+			}
+
+			err = s.Set("key", 42, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			v, err := s.Get("key")
+			if err != nil || v != "42" {
+				t.Fatalf("Get: got %q, %v, want %q", v, err, "42")
+			}
+
+			err = s.Set("expiring", "v", 10*time.Millisecond)
+			if err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(20 * time.Millisecond)
+			_, err = s.Get("expiring")
+			if err != ErrStoreNil {
+				t.Fatalf("Get of an expired key: got error %v, want %v", err, ErrStoreNil)
+			}
+		})
+	}
+}
+
+func TestStoreSAdd(t *testing.T) {
+	for name, newStore := range testStores {
+		t.Run(name, func(t *testing.T) {
+			dir, clean := testTempDir(t)
+			defer clean()
+			s := newStore(t, dir)
+
+			err := s.SAdd("set", 1, "2", 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ms, err := s.SMembers("set")
+			if err != nil {
+				t.Fatal(err)
+			}
+			sort.Strings(ms)
+			if want := []string{"1", "2"}; !reflect.DeepEqual(ms, want) {
+				t.Fatalf("SMembers: got %v, want %v", ms, want)
+			}
+
+			ok, err := s.SIsMember("set", 2)
+			if err != nil || !ok {
+				t.Fatalf("SIsMember: got %v, %v, want true", ok, err)
+			}
+
+			err = s.SRem("set", 1, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ok, err = s.SIsMember("set", 1)
+			if err != nil || ok {
+				t.Fatalf("SIsMember after SRem: got %v, %v, want false", ok, err)
+			}
+		})
+	}
+}
+
+func TestStoreHSet(t *testing.T) {
+	for name, newStore := range testStores {
+		t.Run(name, func(t *testing.T) {
+			dir, clean := testTempDir(t)
+			defer clean()
+			s := newStore(t, dir)
+
+			_, err := s.HGet("hash", "a")
+			if err != ErrStoreNil {
+				t.Fatalf("HGet of a missing field: got error %v, want %v", err, ErrStoreNil)
+			}
+
+			err = s.HSet("hash", "a", 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = s.HSet("hash", "b", "2")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			v, err := s.HGet("hash", "a")
+			if err != nil || v != "1" {
+				t.Fatalf("HGet: got %q, %v, want %q", v, err, "1")
+			}
+
+			all, err := s.HGetAll("hash")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := map[string]string{"a": "1", "b": "2"}; !reflect.DeepEqual(all, want) {
+				t.Fatalf("HGetAll: got %v, want %v", all, want)
+			}
+
+			err = s.HDel("hash", "a")
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = s.HGet("hash", "a")
+			if err != ErrStoreNil {
+				t.Fatalf("HGet after HDel: got error %v, want %v", err, ErrStoreNil)
+			}
+		})
+	}
+}
+
+func TestStoreTakeToken(t *testing.T) {
+	for name, newStore := range testStores {
+		t.Run(name, func(t *testing.T) {
+			dir, clean := testTempDir(t)
+			defer clean()
+			s := newStore(t, dir)
+
+			for i := 0; i < 3; i++ {
+				ok, wait, err := s.TakeToken("bucket", time.Hour, 3)
+				if err != nil || !ok || wait != 0 {
+					t.Fatalf("TakeToken %v: got %v, %v, %v, want true, 0, nil", i, ok, wait, err)
+				}
+			}
+
+			ok, wait, err := s.TakeToken("bucket", time.Hour, 3)
+			if err != nil || ok {
+				t.Fatalf("TakeToken of an empty bucket: got %v, %v, want false, nil", ok, err)
+			}
+			if wait <= 0 || wait > time.Hour {
+				t.Fatalf("TakeToken of an empty bucket: got wait %v, want (0, 1h]", wait)
+			}
+
+			ok, _, err = s.TakeToken("other", time.Hour, 3)
+			if err != nil || !ok {
+				t.Fatalf("TakeToken of another bucket: got %v, %v, want true, nil", ok, err)
+			}
+		})
+	}
+}
+
+func TestStoreFileReload(t *testing.T) {
+	dir, clean := testTempDir(t)
+	defer clean()
+
+	path := filepath.Join(dir, "store.json")
+	s, err := NewStoreFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Set("key", "value", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.SAdd("set", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.HSet("hash", "field", "value")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = NewStoreFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := s.Get("key"); err != nil || v != "value" {
+		t.Errorf("Get after reload: got %q, %v, want %q", v, err, "value")
+	}
+	if ok, err := s.SIsMember("set", "a"); err != nil || !ok {
+		t.Errorf("SIsMember after reload: got %v, %v, want true", ok, err)
+	}
+	if v, err := s.HGet("hash", "field"); err != nil || v != "value" {
+		t.Errorf("HGet after reload: got %q, %v, want %q", v, err, "value")
+	}
+}
+
+func TestStoreRemoveKeepsOtherTypes(t *testing.T) {
+	for name, newStore := range testStores {
+		t.Run(name, func(t *testing.T) {
+			dir, clean := testTempDir(t)
+			defer clean()
+			s := newStore(t, dir)
+
+			err := s.Set("key", "value", 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = s.SAdd("key", "a")
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = s.HSet("key", "field", "value")
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = s.RPush("key", "x")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = s.SRem("key", "a")
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = s.HDel("key", "field")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v, err := s.Get("key"); err != nil || v != "value" {
+				t.Fatalf("Get after SRem and HDel: got %q, %v, want %q", v, err, "value")
+			}
+			if l, err := s.LRange("key", 0, -1); err != nil || !reflect.DeepEqual(l, []string{"x"}) {
+				t.Fatalf("LRange after SRem and HDel: got %v, %v, want [x]", l, err)
+			}
+
+			err = s.LRem("key", 0, "x")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v, err := s.Get("key"); err != nil || v != "value" {
+				t.Fatalf("Get after LRem: got %q, %v, want %q", v, err, "value")
+			}
+		})
+	}
+}
+
+func TestStoreFileSave(t *testing.T) {
+	dir, clean := testTempDir(t)
+	defer clean()
+
+	path := filepath.Join(dir, "store.json")
+	s, err := NewStoreFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Set("expiring", "value", 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.SAdd("set", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	err = s.Set("key", "value", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(raw), "expiring") {
+		t.Errorf("Saved an expired key: %s", raw)
+	}
+
+	err = os.Remove(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.SRem("set", "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.SAdd("set", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Saved the file without any change: %v", err)
+	}
+}
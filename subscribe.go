@@ -10,7 +10,7 @@ import (
 
 // Broadcast sends an update to all chats in the table.
 func (bm *BotMaid) Broadcast(key string, m *Message) {
-	cs := bm.Redis.SMembers("subscribe_" + key).Val()
+	cs, _ := bm.Store.SMembers("subscribe_" + key)
 
 	for _, v := range cs {
 		args := strings.Split(v, "|")
@@ -28,9 +28,20 @@ func (bm *BotMaid) Broadcast(key string, m *Message) {
 	}
 }
 
+// SubscribeCommand returns the command of SubscribeCommandDo with the help,
+// which could only be used by the owners.
+func (bm *BotMaid) SubscribeCommand(h *Help) *Command {
+	return &Command{
+		Do:   bm.SubscribeCommandDo,
+		Role: "owner",
+		Help: h,
+	}
+}
+
+// SubscribeCommandDo subscribes or unsubscribes the chat to an entry, which
+// could only be done by the owners.
 func (bm *BotMaid) SubscribeCommandDo(u *Update, f *pflag.FlagSet) bool {
-	if !bm.IsMaster(u.User) {
-		bm.Reply(u, fmt.Sprintf(bm.Words["noPermission"], bm.At(u.User), "subscribe"))
+	if !bm.CheckPermission(u, "owner", u.Message.Command) {
 		return true
 	}
 
@@ -40,13 +51,13 @@ func (bm *BotMaid) SubscribeCommandDo(u *Update, f *pflag.FlagSet) bool {
 	}
 
 	if len(f.Args()) == 2 {
-		if bm.Redis.SIsMember("subscribe_"+f.Args()[1], u.Bot.ID+"|"+u.Chat.Type+"|"+strconv.FormatInt(u.Chat.ID, 10)).Val() {
-			bm.Redis.SRem("subscribe_"+f.Args()[1], u.Bot.ID+"|"+u.Chat.Type+"|"+strconv.FormatInt(u.Chat.ID, 10))
+		if is, _ := bm.Store.SIsMember("subscribe_"+f.Args()[1], u.Bot.ID+"|"+u.Chat.Type+"|"+strconv.FormatInt(u.Chat.ID, 10)); is {
+			bm.Store.SRem("subscribe_"+f.Args()[1], u.Bot.ID+"|"+u.Chat.Type+"|"+strconv.FormatInt(u.Chat.ID, 10))
 			bm.Reply(u, fmt.Sprintf(bm.Words["unsubscribed"], f.Args()[1]))
 			return true
 		}
 
-		bm.Redis.SAdd("subscribe_"+f.Args()[1], u.Bot.ID+"|"+u.Chat.Type+"|"+strconv.FormatInt(u.Chat.ID, 10))
+		bm.Store.SAdd("subscribe_"+f.Args()[1], u.Bot.ID+"|"+u.Chat.Type+"|"+strconv.FormatInt(u.Chat.ID, 10))
 		bm.Reply(u, fmt.Sprintf(bm.Words["subscribed"], f.Args()[1]))
 		return true
 	}
@@ -1,15 +1,26 @@
 package botmaid
 
 import (
+	"context"
+	"fmt"
 	"time"
 )
 
 // Timer is a func with time and frequency so that we can call it at some
 // specific time.
+//
+// If Cron is not empty, the timer is called at the times matching the cron
+// expression (see ParseCron) in Location (the local time zone if nil)
+// between Start and End instead of every Frequency.
 type Timer struct {
 	Do         func()
 	Start, End time.Time
 	Frequency  time.Duration
+
+	Cron     string
+	Location *time.Location
+
+	schedule *CronSchedule
 }
 
 // AddTimer adds a timer into the []Timer.
@@ -17,16 +28,83 @@ func (bm *BotMaid) AddTimer(t *Timer) {
 	bm.Timers = append(bm.Timers, t)
 }
 
-func (bm *BotMaid) loadTimers() {
+func (bm *BotMaid) parseTimers() error {
+	for _, t := range bm.Timers {
+		if t.Cron == "" {
+			continue
+		}
+
+		s, err := ParseCron(t.Cron)
+		if err != nil {
+			return fmt.Errorf("Init botmaid: Timer: %v", err)
+		}
+		t.schedule = s
+	}
+
+	return nil
+}
+
+func (t *Timer) next(after time.Time) time.Time {
+	if t.Start.After(after) {
+		after = t.Start.Add(-time.Nanosecond)
+	}
+
+	loc := t.Location
+	if loc == nil {
+		loc = time.Local
+	}
+
+	return t.schedule.Next(after.In(loc))
+}
+
+func (bm *BotMaid) loadCronTimer(ctx context.Context, t *Timer) {
+	next := time.Now()
+
+	for {
+		next = t.next(next)
+		if next.IsZero() || (!t.End.IsZero() && next.After(t.End)) {
+			return
+		}
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+		t.Do()
+
+		if time.Now().After(next) {
+			next = time.Now()
+		}
+	}
+}
+
+func (bm *BotMaid) loadTimers(ctx context.Context) {
 	for _, t := range bm.Timers {
 		tm := t
+
+		if tm.schedule != nil {
+			bm.wg.Add(1)
+			go func(t *Timer) {
+				defer bm.wg.Done()
+
+				bm.loadCronTimer(ctx, t)
+			}(tm)
+			continue
+		}
+
 		next := tm.Start
 
 		if tm.Frequency == 0 && time.Now().After(next) {
 			continue
 		}
 
+		bm.wg.Add(1)
 		go func(t *Timer) {
+			defer bm.wg.Done()
+
 			for {
 				for time.Now().After(next) {
 					next = next.Add(t.Frequency)
@@ -37,7 +115,12 @@ func (bm *BotMaid) loadTimers() {
 				}
 
 				timer := time.NewTimer(-time.Since(next))
-				<-timer.C
+				select {
+				case <-timer.C:
+				case <-ctx.Done():
+					timer.Stop()
+					return
+				}
 				t.Do()
 
 				if t.Frequency == 0 {
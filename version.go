@@ -8,12 +8,13 @@ import (
 
 func (bm *BotMaid) getLog() string {
 	log := ""
-	l := bm.Redis.LRange("log_"+bm.Redis.Get("version").Val(), 0, -1).Val()
+	v, _ := bm.Store.Get("version")
+	l, _ := bm.Store.LRange("log_"+v, 0, -1)
 	for i := range l {
 		log += fmt.Sprintf("\n%v. %v", i+1, l[i])
 	}
 
-	return fmt.Sprintf(bm.Words["fmtLog"], bm.Redis.Get("version").Val(), log)
+	return fmt.Sprintf(bm.Words["fmtLog"], v, log)
 }
 
 func (bm *BotMaid) VersionCommandDo(u *Update, f *pflag.FlagSet) bool {
@@ -23,7 +24,8 @@ func (bm *BotMaid) VersionCommandDo(u *Update, f *pflag.FlagSet) bool {
 		return true
 	}
 
-	bm.Reply(u, fmt.Sprintf(bm.Words["fmtVersion"], bm.Redis.Get("version").Val()))
+	v, _ := bm.Store.Get("version")
+	bm.Reply(u, fmt.Sprintf(bm.Words["fmtVersion"], v))
 	return true
 }
 
@@ -31,9 +33,24 @@ func (bm *BotMaid) VersionCommandHelpSetFlag(f *pflag.FlagSet) {
 	f.BoolP("log", "l", false, bm.Words["versionLogHelp"])
 }
 
+// VersetCommand returns the command of VersetCommandDo with the help, which
+// could only be used by the owners.
+func (bm *BotMaid) VersetCommand(h *Help) *Command {
+	if h != nil && h.SetFlag == nil {
+		h.SetFlag = bm.VersetCommandHelpSetFlag
+	}
+
+	return &Command{
+		Do:   bm.VersetCommandDo,
+		Role: "owner",
+		Help: h,
+	}
+}
+
+// VersetCommandDo sets the version and the change log, which could only be
+// done by the owners.
 func (bm *BotMaid) VersetCommandDo(u *Update, f *pflag.FlagSet) bool {
-	if !bm.IsMaster(u.User) {
-		bm.Reply(u, fmt.Sprintf(bm.Words["noPermission"], bm.At(u.User), "verset"))
+	if !bm.CheckPermission(u, "owner", u.Message.Command) {
 		return true
 	}
 
@@ -46,7 +63,7 @@ func (bm *BotMaid) VersetCommandDo(u *Update, f *pflag.FlagSet) bool {
 	}
 
 	flag := false
-	v := bm.Redis.Get("version").Val()
+	v, _ := bm.Store.Get("version")
 
 	ver, _ := f.GetString("ver")
 	if ver != "" {
@@ -54,14 +71,14 @@ func (bm *BotMaid) VersetCommandDo(u *Update, f *pflag.FlagSet) bool {
 	}
 
 	if len(f.Args()) == 2 {
-		bm.Redis.Set("version", f.Args()[1], 0)
+		bm.Store.Set("version", f.Args()[1], 0)
 		bm.Reply(u, fmt.Sprintf(bm.Words["versionSet"], f.Args()[1]))
 		flag = true
 	}
 
 	log, _ := f.GetString("log")
 	if log != "" {
-		bm.Redis.RPush("log_"+v, log)
+		bm.Store.RPush("log_"+v, log)
 		bm.Reply(u, fmt.Sprintf(bm.Words["logAdded"], log))
 		flag = true
 	}